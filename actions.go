@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+	"github.com/yuichiro-h/cwl-alert-notifier/log"
+	"github.com/yuichiro-h/cwl-alert-notifier/render"
+	"github.com/yuichiro-h/cwl-alert-notifier/silence"
+	"github.com/yuichiro-h/cwl-alert-notifier/state"
+	"go.uber.org/zap"
+)
+
+const (
+	actionAcknowledge     = "ack"
+	actionMute            = "mute"
+	actionMuteFingerprint = "mute_fingerprint"
+)
+
+const (
+	// muteDuration is how long the Mute button silences the application.
+	muteDuration = time.Hour
+	// muteFingerprintDuration is how long the Mute error menu silences the error.
+	muteFingerprintDuration = 24 * time.Hour
+	// muteOptionLength is how much of an error is shown in the Mute error menu.
+	muteOptionLength = 60
+)
+
+// notificationActions is what the interactive buttons of a notification act
+// on. It is kept as long as deliveries are.
+type notificationActions struct {
+	AlarmName       string `json:"alarm_name"`
+	ApplicationName string `json:"application_name"`
+	// Fingerprints are the errors shown in the notification, in the Mute error menu.
+	Fingerprints []string `json:"fingerprints,omitempty"`
+	// Fingerprint is the error of the Mute error button of notifications
+	// posted before the menu.
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	AckedBy     string    `json:"acked_by,omitempty"`
+	AckedAt     time.Time `json:"acked_at,omitempty"`
+}
+
+func actionsKey(id string) string {
+	return "actions/" + id
+}
+
+func saveActions(ctx context.Context, store state.Store, id string, a *notificationActions) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(store.Put(ctx, actionsKey(id), data, deliveryTTL))
+}
+
+// addActions stores what the buttons of the notification act on and returns
+// the buttons, identified by id as callback. The Mute error menu lists each
+// error shown in the notification.
+func addActions(ctx context.Context, store state.Store, id string, in *notifyInput) ([]slack.AttachmentAction, error) {
+	a := notificationActions{
+		ApplicationName: in.ApplicationName,
+	}
+	if in.Alarm != nil {
+		a.AlarmName = in.Alarm.AlarmName
+	}
+	clusters := render.Cluster(in.Events)
+	if in.MaxDisplayed > 0 && len(clusters) > in.MaxDisplayed {
+		clusters = clusters[:in.MaxDisplayed]
+	}
+	var options []slack.AttachmentActionOption
+	for _, c := range clusters {
+		fp := c.Fingerprint()
+		a.Fingerprints = append(a.Fingerprints, fp)
+		options = append(options, slack.AttachmentActionOption{
+			Text:  render.Truncate(muteOptionLength, muteOptionText(c)),
+			Value: fp,
+		})
+	}
+	if err := saveActions(ctx, store, id, &a); err != nil {
+		return nil, err
+	}
+
+	return []slack.AttachmentAction{
+		{Name: "action", Type: "button", Text: "Acknowledge", Value: actionAcknowledge, Style: "primary"},
+		{Name: "action", Type: "button", Text: "Mute 1h", Value: actionMute},
+		{
+			Name:    actionMuteFingerprint,
+			Type:    "select",
+			Text:    "Mute error",
+			Options: options,
+			Confirm: &slack.ConfirmationField{
+				Text: fmt.Sprintf("Mute the selected error of %s for %s?", in.ApplicationName, muteFingerprintDuration),
+			},
+		},
+	}, nil
+}
+
+// muteOptionText is the first line of the summary or message of e.
+func muteOptionText(e render.Event) string {
+	text := e.Summary
+	if text == "" {
+		text = e.Message
+	}
+	return strings.TrimSpace(strings.SplitN(strings.TrimSpace(text), "\n", 2)[0])
+}
+
+// slackActions handles the interactive buttons of notifications and replaces
+// the message with what was done.
+func (a *apiServer) slackActions(w http.ResponseWriter, r *http.Request) {
+	var cb slack.AttachmentActionCallback
+	if err := json.Unmarshal([]byte(r.FormValue("payload")), &cb); err != nil {
+		writeError(w, http.StatusBadRequest, errors.WithStack(err))
+		return
+	}
+	if len(cb.Actions) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("no action"))
+		return
+	}
+
+	ctx := r.Context()
+	data, err := a.svc.store.Get(ctx, actionsKey(cb.CallbackID))
+	if err == state.ErrNotFound {
+		writeJSON(w, http.StatusOK, slack.Msg{
+			ResponseType:    "ephemeral",
+			ReplaceOriginal: false,
+			Text:            "This notification is too old to act on.",
+		})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errors.WithStack(err))
+		return
+	}
+	var na notificationActions
+	if err := json.Unmarshal(data, &na); err != nil {
+		writeError(w, http.StatusInternalServerError, errors.WithStack(err))
+		return
+	}
+
+	// メニューは選択肢の値にエラーの fingerprint が入る
+	action, fingerprint := cb.Actions[0].Value, na.Fingerprint
+	if selected := cb.Actions[0].SelectedOptions; len(selected) > 0 {
+		action, fingerprint = cb.Actions[0].Name, selected[0].Value
+		if !containsString(na.Fingerprints, fingerprint) {
+			writeError(w, http.StatusBadRequest, errors.Errorf("unknown error %q", fingerprint))
+			return
+		}
+	}
+
+	user := "<@" + cb.User.ID + ">"
+	now := time.Now()
+	var note string
+	switch action {
+	case actionAcknowledge:
+		if na.AckedBy == "" {
+			na.AckedBy = user
+			na.AckedAt = now
+			if err := saveActions(ctx, a.svc.store, cb.CallbackID, &na); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		note = fmt.Sprintf(":white_check_mark: Acknowledged by %s", na.AckedBy)
+
+	case actionMute, actionMuteFingerprint:
+		s := silence.Silence{
+			Alarm:     globQuote(na.AlarmName),
+			App:       globQuote(na.ApplicationName),
+			StartsAt:  now,
+			EndsAt:    now.Add(muteDuration),
+			CreatedBy: cb.User.Name,
+			Comment:   "Muted from Slack",
+		}
+		if action == actionMuteFingerprint {
+			if fingerprint == "" {
+				writeError(w, http.StatusBadRequest, errors.New("no error selected"))
+				return
+			}
+			s.Fingerprint = fingerprint
+			s.EndsAt = now.Add(muteFingerprintDuration)
+		}
+		created, err := a.svc.silencer.Create(ctx, s)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.Get().Info("created silence", zap.String("silence_id", created.ID), zap.String("silence", created.String()))
+		note = fmt.Sprintf(":no_bell: %s muted %s until %s (silence `%s`)",
+			user, created.String(), created.EndsAt.Local().Format("2006-01-02 15:04"), created.ID)
+
+	default:
+		writeError(w, http.StatusBadRequest, errors.Errorf("unknown action %q", action))
+		return
+	}
+
+	// 元のメッセージに実施内容を追記して置き換える
+	msg := cb.OriginalMessage.Msg
+	for i := range msg.Attachments {
+		var actions []slack.AttachmentAction
+		for _, act := range msg.Attachments[i].Actions {
+			if act.Value == actionAcknowledge && na.AckedBy != "" {
+				continue
+			}
+			actions = append(actions, act)
+		}
+		msg.Attachments[i].Actions = actions
+	}
+	msg.Attachments = append(msg.Attachments, slack.Attachment{
+		Text:       note,
+		MarkdownIn: []string{"text"},
+	})
+	msg.ReplaceOriginal = true
+	writeJSON(w, http.StatusOK, msg)
+}
+
+// globQuote escapes s to match itself as a glob pattern.
+func globQuote(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]{}\!`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -2,6 +2,7 @@ package main
 
 type CloudWatchAlarm struct {
 	AlarmName        string       `json:"AlarmName"`
+	AlarmArn         string       `json:"AlarmArn"`
 	AlarmDescription *string      `json:"AlarmDescription"`
 	AWSAccountID     string       `json:"AWSAccountId"`
 	NewStateValue    string       `json:"NewStateValue"`
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi/resourcegroupstaggingapiiface"
+	"github.com/pkg/errors"
+)
+
+// alarmTagsTTL is how long the tags of the CloudWatch alarms are cached.
+const alarmTagsTTL = 5 * time.Minute
+
+// alarmTags looks up the tags of CloudWatch alarms. The tags of all alarms
+// are fetched at once and cached, as alarms are notified repeatedly.
+type alarmTags struct {
+	api resourcegroupstaggingapiiface.ResourceGroupsTaggingAPIAPI
+
+	mu        sync.Mutex
+	tags      map[string]map[string]string
+	fetchedAt time.Time
+}
+
+// Get returns the tags of the alarm of arn.
+func (t *alarmTags) Get(ctx context.Context, arn string) (map[string]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if time.Since(t.fetchedAt) > alarmTagsTTL {
+		tags := map[string]map[string]string{}
+		err := t.api.GetResourcesPagesWithContext(ctx, &resourcegroupstaggingapi.GetResourcesInput{
+			ResourceTypeFilters: aws.StringSlice([]string{"cloudwatch:alarm"}),
+		}, func(out *resourcegroupstaggingapi.GetResourcesOutput, last bool) bool {
+			for _, r := range out.ResourceTagMappingList {
+				m := map[string]string{}
+				for _, tag := range r.Tags {
+					m[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+				}
+				tags[aws.StringValue(r.ResourceARN)] = m
+			}
+			return true
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		t.tags = tags
+		t.fetchedAt = time.Now()
+	}
+	return t.tags[arn], nil
+}
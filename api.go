@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gobwas/glob"
+	"github.com/pkg/errors"
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/log"
+	"github.com/yuichiro-h/cwl-alert-notifier/silence"
+	"github.com/yuichiro-h/cwl-alert-notifier/state"
+	"go.uber.org/zap"
+)
+
+// apiServer serves the HTTP API used to manage the notifier at runtime.
+type apiServer struct {
+	svc *services
+}
+
+func newHTTPServer(listen string, svc *services) *http.Server {
+	api := &apiServer{svc: svc}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/silences", api.authorize(http.HandlerFunc(api.silences)))
+	mux.Handle("/api/silences/", api.authorize(http.HandlerFunc(api.silence)))
+	mux.Handle("/api/history", api.authorize(http.HandlerFunc(api.history)))
+	mux.Handle("/slack/actions", api.verifySlack(http.HandlerFunc(api.slackActions)))
+	mux.Handle("/slack/commands", api.verifySlack(http.HandlerFunc(api.slackCommand)))
+
+	return &http.Server{
+		Addr:    listen,
+		Handler: mux,
+	}
+}
+
+// authorize requires the bearer token of http.api_token. Without a token the
+// API is refused, although the config validation already requires one.
+func (a *apiServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := config.Get().HTTP.ApiToken
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(given)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type createSilenceRequest struct {
+	silence.Silence
+	// Duration is used instead of ends_at when set, e.g. "2h".
+	Duration string `json:"duration"`
+}
+
+// silences lists silences on GET and creates one on POST.
+func (a *apiServer) silences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		silences, err := a.svc.silencer.List(r.Context(), config.Get().Silences.Rules)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if silences == nil {
+			silences = []silence.Silence{}
+		}
+		writeJSON(w, http.StatusOK, silences)
+
+	case http.MethodPost:
+		var req createSilenceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.StartsAt.IsZero() {
+			req.StartsAt = time.Now()
+		}
+		if req.Duration != "" {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			req.EndsAt = req.StartsAt.Add(d)
+		}
+		req.ID = ""
+
+		s, err := a.svc.silencer.Create(r.Context(), req.Silence)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Get().Info("created silence", zap.String("silence_id", s.ID), zap.String("silence", s.String()))
+		writeJSON(w, http.StatusCreated, s)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// silence expires the silence of the path on DELETE.
+func (a *apiServer) silence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/silences/")
+	s, err := a.svc.silencer.Expire(r.Context(), id)
+	if errors.Cause(err) == state.ErrNotFound {
+		writeError(w, http.StatusNotFound, errors.Errorf("not found silence %s", id))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Get().Info("expired silence", zap.String("silence_id", s.ID))
+	writeJSON(w, http.StatusOK, s)
+}
+
+// maxHistoryLimit caps the records returned at once.
+const maxHistoryLimit = 1000
+
+// history lists processed alarm messages, newest first, filtered by the query
+// parameters from, to (RFC 3339, the last 24 hours by default), alarm and app
+// (globs), status (comma separated) and limit.
+func (a *apiServer) history(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	q, err := parseHistoryQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	records, err := a.svc.history.Find(r.Context(), q)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+func parseHistoryQuery(v url.Values, now time.Time) (historyQuery, error) {
+	q := historyQuery{
+		From:  now.Add(-24 * time.Hour),
+		Limit: 100,
+	}
+
+	var err error
+	if s := v.Get("from"); s != "" {
+		if q.From, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, errors.Errorf("invalid from: %s", s)
+		}
+	}
+	if s := v.Get("to"); s != "" {
+		if q.To, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, errors.Errorf("invalid to: %s", s)
+		}
+	}
+	if s := v.Get("alarm"); s != "" {
+		if q.Alarm, err = glob.Compile(s); err != nil {
+			return q, errors.Errorf("invalid alarm: %s", s)
+		}
+	}
+	if s := v.Get("app"); s != "" {
+		if q.App, err = glob.Compile(s); err != nil {
+			return q, errors.Errorf("invalid app: %s", s)
+		}
+	}
+	if s := v.Get("status"); s != "" {
+		q.Status = strings.Split(s, ",")
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 1 || q.Limit > maxHistoryLimit {
+			return q, errors.Errorf("limit must be between 1 and %d: %s", maxHistoryLimit, s)
+		}
+	}
+	return q, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Get().Error(err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		log.Get().Error(err.Error())
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
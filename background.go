@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/log"
+	"go.uber.org/zap"
+)
+
+// runEvery calls fn with the current config every interval until stop is closed.
+func runEvery(ctx context.Context, stop <-chan struct{}, interval time.Duration, name string, fn func(context.Context, *config.Config) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := fn(ctx, config.Get()); err != nil {
+				log.Get().Error("failed to "+name, zap.Error(err))
+			}
+		}
+	}
+}
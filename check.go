@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+)
+
+// runCheck validates a config file and prints the effective Slack settings
+// of every alarm and group. It returns the process exit code.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	path := fs.String("config", os.Getenv("CONFIG_PATH"), "path to the config file")
+	fs.Parse(args)
+
+	if *path == "" {
+		fmt.Fprintln(os.Stderr, "config path is required (-config or CONFIG_PATH)")
+		return 2
+	}
+
+	conf, err := config.Parse(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	printEffectiveSlack(os.Stdout, conf)
+	fmt.Fprintf(os.Stdout, "%s: OK\n", *path)
+	return 0
+}
+
+func printEffectiveSlack(w io.Writer, conf *config.Config) {
+	for _, name := range conf.AlarmNames() {
+		alarm := conf.Alarms[name]
+		fmt.Fprintf(w, "alarm %s (%s)\n", name, alarm.SqsURL)
+		fmt.Fprintf(w, "  default:\n")
+		printSlack(w, "    ", conf.EffectiveSlack(alarm, nil))
+
+		for i := range alarm.Groups {
+			g := &alarm.Groups[i]
+			var matchers []string
+			matchers = append(matchers, g.LogGroups...)
+			for _, def := range g.AWSBatchJobDefinitions {
+				matchers = append(matchers, def+"(AWS Batch)")
+			}
+			fmt.Fprintf(w, "  group[%d] %s:\n", i, strings.Join(matchers, ", "))
+			printSlack(w, "    ", conf.EffectiveSlack(alarm, g))
+		}
+	}
+}
+
+func printSlack(w io.Writer, indent string, s config.SlackConfig) {
+	fmt.Fprintf(w, "%schannel: %s\n", indent, s.Channel)
+	fmt.Fprintf(w, "%susername: %s\n", indent, s.Username)
+	fmt.Fprintf(w, "%sicon_url: %s\n", indent, s.IconURL)
+	fmt.Fprintf(w, "%sattachment_color: %s\n", indent, s.AttachmentColor)
+	fmt.Fprintf(w, "%sapi_token: %s\n", indent, maskSecret(s.ApiToken))
+}
+
+func maskSecret(s string) string {
+	if len(s) <= 8 {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:4] + strings.Repeat("*", len(s)-4)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gobwas/glob"
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/log"
+	"github.com/yuichiro-h/cwl-alert-notifier/silence"
+	"github.com/yuichiro-h/cwl-alert-notifier/state"
+	"go.uber.org/zap"
+)
+
+// recentPeriod is how far back the recent command looks.
+const recentPeriod = 24 * time.Hour
+
+// recentLimit is how many applications the recent command lists.
+const recentLimit = 10
+
+const commandUsage = "Usage:\n" +
+	"• `%[1]s recent [app]` lists the alerts of the last 24 hours\n" +
+	"• `%[1]s silence <app> <duration> [comment]` mutes an application, e.g. `batch-* 2h`\n" +
+	"• `%[1]s silences` lists the silences\n" +
+	"• `%[1]s unsilence <id>` expires a silence"
+
+// slackCommand handles the slash command. Queries are answered only to the
+// user, while changes to silences are posted to the channel.
+func (a *apiServer) slackCommand(w http.ResponseWriter, r *http.Request) {
+	cmd, err := slack.SlashCommandParse(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.WithStack(err))
+		return
+	}
+
+	args := strings.Fields(cmd.Text)
+	var sub string
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+
+	var msg *slack.Msg
+	switch {
+	case sub == "recent" && len(args) <= 1:
+		msg, err = a.recentCommand(r.Context(), args)
+	case sub == "silence" && len(args) >= 2:
+		msg, err = a.silenceCommand(r.Context(), cmd, args)
+	case sub == "silences" && len(args) == 0:
+		msg, err = a.silencesCommand(r.Context())
+	case sub == "unsilence" && len(args) == 1:
+		msg, err = a.unsilenceCommand(r.Context(), cmd, args[0])
+	default:
+		msg = ephemeral(fmt.Sprintf(commandUsage, cmd.Command))
+	}
+	if err != nil {
+		log.Get().Error("failed to run slack command", zap.String("text", cmd.Text), zap.Error(err))
+		msg = ephemeral(fmt.Sprintf("Failed: %s", errors.Cause(err)))
+	}
+	writeJSON(w, http.StatusOK, msg)
+}
+
+func ephemeral(text string) *slack.Msg {
+	return &slack.Msg{ResponseType: "ephemeral", Text: text}
+}
+
+func inChannel(text string) *slack.Msg {
+	return &slack.Msg{ResponseType: "in_channel", Text: text}
+}
+
+// slackDate formats t to be shown in the time zone of the reader.
+func slackDate(t time.Time) string {
+	return fmt.Sprintf("<!date^%d^{date_short_pretty} {time}|%s>", t.Unix(), t.UTC().Format(time.RFC3339))
+}
+
+func (a *apiServer) recentCommand(ctx context.Context, args []string) (*slack.Msg, error) {
+	pattern := "*"
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+	g, err := glob.Compile(pattern)
+	if err != nil {
+		return ephemeral(fmt.Sprintf("Invalid pattern `%s`: %s", pattern, err)), nil
+	}
+
+	now := time.Now()
+	stats, err := a.svc.stats.Between(ctx, now.Add(-recentPeriod).Truncate(time.Hour), now)
+	if err != nil {
+		return nil, err
+	}
+	var apps []alertStat
+	samples := map[string][]alertStat{}
+	for _, st := range stats {
+		if !g.Match(st.ApplicationName) {
+			continue
+		}
+		if st.Fingerprint == "" {
+			apps = append(apps, st)
+		} else {
+			k := st.Channel + "\x00" + st.ApplicationName
+			samples[k] = append(samples[k], st)
+		}
+	}
+	if len(apps) == 0 {
+		return ephemeral(fmt.Sprintf("No alerts of `%s` in the last 24 hours.", pattern)), nil
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].LastSeen.After(apps[j].LastSeen) })
+
+	b := strings.Builder{}
+	b.WriteString(fmt.Sprintf("Alerts of `%s` in the last 24 hours:\n", pattern))
+	for i, st := range apps {
+		if i == recentLimit {
+			b.WriteString(fmt.Sprintf("…and %d more application(s)\n", len(apps)-recentLimit))
+			break
+		}
+		b.WriteString(fmt.Sprintf("• *%s* in %s: %d alert(s), %d event(s), last %s <%s|Open log>\n",
+			st.ApplicationName, st.Channel, st.Notifications, st.Events, slackDate(st.LastSeen), st.LastLogURL))
+
+		errs := samples[st.Channel+"\x00"+st.ApplicationName]
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Events > errs[j].Events })
+		for j, e := range errs {
+			if j == 3 {
+				break
+			}
+			b.WriteString(fmt.Sprintf("    ◦ ×%d `%s` `%s`\n", e.Events, e.Fingerprint, truncate(e.Sample, 80)))
+		}
+	}
+	return ephemeral(b.String()), nil
+}
+
+func (a *apiServer) silenceCommand(ctx context.Context, cmd slack.SlashCommand, args []string) (*slack.Msg, error) {
+	d, err := time.ParseDuration(args[1])
+	if err != nil || d <= 0 {
+		return ephemeral(fmt.Sprintf("Invalid duration `%s`, e.g. `30m` or `2h`.", args[1])), nil
+	}
+
+	now := time.Now()
+	s, err := a.svc.silencer.Create(ctx, silence.Silence{
+		App:       args[0],
+		StartsAt:  now,
+		EndsAt:    now.Add(d),
+		CreatedBy: cmd.UserName,
+		Comment:   strings.Join(args[2:], " "),
+	})
+	if err != nil {
+		return ephemeral(fmt.Sprintf("Invalid silence: %s", errors.Cause(err))), nil
+	}
+	log.Get().Info("created silence", zap.String("silence_id", s.ID), zap.String("silence", s.String()))
+	return inChannel(fmt.Sprintf(":no_bell: <@%s> muted %s until %s (silence `%s`)",
+		cmd.UserID, s.String(), slackDate(s.EndsAt), s.ID)), nil
+}
+
+func (a *apiServer) silencesCommand(ctx context.Context) (*slack.Msg, error) {
+	silences, err := a.svc.silencer.List(ctx, config.Get().Silences.Rules)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	b := strings.Builder{}
+	for _, s := range silences {
+		if !now.Before(s.EndsAt) {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("• `%s` %s until %s", s.ID, s.String(), slackDate(s.EndsAt)))
+		if s.CreatedBy != "" {
+			b.WriteString(" by " + s.CreatedBy)
+		}
+		if s.Comment != "" {
+			b.WriteString(" — " + s.Comment)
+		}
+		b.WriteString("\n")
+	}
+	if b.Len() == 0 {
+		return ephemeral("No silences."), nil
+	}
+	return ephemeral(b.String()), nil
+}
+
+func (a *apiServer) unsilenceCommand(ctx context.Context, cmd slack.SlashCommand, id string) (*slack.Msg, error) {
+	s, err := a.svc.silencer.Expire(ctx, id)
+	if errors.Cause(err) == state.ErrNotFound {
+		return ephemeral(fmt.Sprintf("Not found silence `%s`.", id)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Get().Info("expired silence", zap.String("silence_id", s.ID))
+	return inChannel(fmt.Sprintf(":bell: <@%s> expired silence `%s` (%s)", cmd.UserID, s.ID, s.String())), nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/render"
+)
+
+// runCommand runs the slash command with text through the signed endpoint.
+func runCommand(t *testing.T, svc *services, text string) slack.Msg {
+	t.Helper()
+	w := serveSlack(svc, "/slack/commands", url.Values{
+		"command":   {"/cwl-alerts"},
+		"text":      {text},
+		"user_id":   {"U1"},
+		"user_name": {"alice"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("%q answered %d: %s", text, w.Code, w.Body)
+	}
+	var msg slack.Msg
+	if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestSlackCommandRecent(t *testing.T) {
+	loadTestConfig(t)
+	svc := newTestServices()
+	ctx := context.Background()
+
+	now := time.Now()
+	for _, in := range []*notifyInput{
+		{
+			ApplicationName: "batch-a",
+			Slack:           config.SlackConfig{Channel: "#batch"},
+			FirstLogURL:     "https://console/a",
+			Events:          []render.Event{{Message: "ERROR timeout"}, {Message: "ERROR timeout"}},
+		},
+		{
+			ApplicationName: "web",
+			Slack:           config.SlackConfig{Channel: "#web"},
+			FirstLogURL:     "https://console/web",
+			Events:          []render.Event{{Message: "ERROR refused"}},
+		},
+	} {
+		if err := svc.stats.Record(ctx, in, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg := runCommand(t, svc, "recent batch-*")
+	if msg.ResponseType != "ephemeral" {
+		t.Errorf("response type = %q, want ephemeral", msg.ResponseType)
+	}
+	if !strings.Contains(msg.Text, "*batch-a* in #batch: 1 alert(s), 2 event(s)") || !strings.Contains(msg.Text, "×2") {
+		t.Errorf("recent batch-* =\n%s", msg.Text)
+	}
+	if strings.Contains(msg.Text, "web") {
+		t.Errorf("recent batch-* lists another application:\n%s", msg.Text)
+	}
+
+	if msg := runCommand(t, svc, "recent"); !strings.Contains(msg.Text, "*web*") || !strings.Contains(msg.Text, "*batch-a*") {
+		t.Errorf("recent =\n%s", msg.Text)
+	}
+	if msg := runCommand(t, svc, "recent api"); msg.Text != "No alerts of `api` in the last 24 hours." {
+		t.Errorf("recent api = %q", msg.Text)
+	}
+	if msg := runCommand(t, svc, "recent ["); !strings.HasPrefix(msg.Text, "Invalid pattern `[`") {
+		t.Errorf("recent [ = %q", msg.Text)
+	}
+}
+
+func TestSlackCommandSilences(t *testing.T) {
+	loadTestConfig(t)
+	svc := newTestServices()
+
+	if msg := runCommand(t, svc, "silences"); msg.Text != "No silences." {
+		t.Errorf("silences = %q", msg.Text)
+	}
+
+	before := time.Now()
+	msg := runCommand(t, svc, "silence batch-* 2h deploying batch")
+	if msg.ResponseType != "in_channel" {
+		t.Errorf("response type = %q, want in_channel", msg.ResponseType)
+	}
+	m := regexp.MustCompile("silence `([0-9a-f]+)`").FindStringSubmatch(msg.Text)
+	if m == nil || !strings.Contains(msg.Text, `<@U1> muted app="batch-*"`) {
+		t.Fatalf("silence = %q", msg.Text)
+	}
+	id := m[1]
+
+	silences, err := svc.silencer.List(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(silences) != 1 {
+		t.Fatalf("silences = %+v, want 1", silences)
+	}
+	s := silences[0]
+	if s.ID != id || s.App != "batch-*" || s.CreatedBy != "alice" || s.Comment != "deploying batch" {
+		t.Errorf("silence = %+v", s)
+	}
+	if d := s.EndsAt.Sub(before); d < 2*time.Hour || d > 2*time.Hour+time.Minute {
+		t.Errorf("silence lasts %s, want 2h", d)
+	}
+
+	msg = runCommand(t, svc, "silences")
+	if !strings.Contains(msg.Text, "`"+id+"` app=\"batch-*\"") || !strings.Contains(msg.Text, "by alice — deploying batch") {
+		t.Errorf("silences = %q", msg.Text)
+	}
+
+	msg = runCommand(t, svc, "unsilence "+id)
+	if msg.ResponseType != "in_channel" || !strings.Contains(msg.Text, "expired silence `"+id+"`") {
+		t.Errorf("unsilence = %+v", msg)
+	}
+	if msg := runCommand(t, svc, "silences"); msg.Text != "No silences." {
+		t.Errorf("silences after unsilence = %q", msg.Text)
+	}
+}
+
+func TestSlackCommandErrors(t *testing.T) {
+	loadTestConfig(t)
+	svc := newTestServices()
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"silence batch-* 2 hours", "Invalid duration `2`, e.g. `30m` or `2h`."},
+		{"silence batch-* -1h", "Invalid duration `-1h`, e.g. `30m` or `2h`."},
+		{"silence batch-* 0s", "Invalid duration `0s`, e.g. `30m` or `2h`."},
+		{"silence [ 1h", "Invalid silence: "},
+		{"unsilence 0123456789ab", "Not found silence `0123456789ab`."},
+		{"", "Usage:"},
+		{"silence batch-*", "Usage:"},
+		{"unsilence", "Usage:"},
+		{"silences all", "Usage:"},
+		{"recent a b", "Usage:"},
+		{"mute batch 1h", "Usage:"},
+	}
+	for _, tt := range tests {
+		msg := runCommand(t, svc, tt.text)
+		if msg.ResponseType != "ephemeral" || !strings.HasPrefix(msg.Text, tt.want) {
+			t.Errorf("%q = %+v, want %q", tt.text, msg, tt.want)
+		}
+	}
+
+	silences, err := svc.silencer.List(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(silences) != 0 {
+		t.Errorf("invalid commands created silences %+v", silences)
+	}
+}
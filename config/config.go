@@ -2,12 +2,21 @@ package config
 
 import (
 	"io/ioutil"
+	"regexp"
+	"sort"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/yuichiro-h/cwl-alert-notifier/multiline"
+	"github.com/yuichiro-h/cwl-alert-notifier/redact"
+	"github.com/yuichiro-h/cwl-alert-notifier/render"
+	"github.com/yuichiro-h/cwl-alert-notifier/silence"
+	"github.com/yuichiro-h/cwl-alert-notifier/window"
 	"gopkg.in/yaml.v2"
 )
 
-var c Config
+var current atomic.Value
 
 type AlarmName string
 
@@ -23,10 +32,211 @@ type Config struct {
 			Before *int64 `yaml:"before"`
 			After  *int64 `yaml:"after"`
 		} `yaml:"range_duration"`
+		// MaxEvents is how many log events are fetched per alarm at most.
+		MaxEvents *int `yaml:"max_events"`
+		// MaxDisplayedEvents is how many log events are shown per application.
+		// The rest are summarized.
+		MaxDisplayedEvents *int `yaml:"max_displayed_events"`
 	} `yaml:"log"`
 
-	Slack  SlackConfig         `yaml:"slack"`
-	Alarms map[AlarmName]Alarm `yaml:"alarms"`
+	Reload struct {
+		WatchInterval *int64 `yaml:"watch_interval"`
+	} `yaml:"reload"`
+
+	Shutdown struct {
+		Timeout *int64 `yaml:"timeout"`
+	} `yaml:"shutdown"`
+
+	Retry   RetryConfig   `yaml:"retry"`
+	State   StateConfig   `yaml:"state"`
+	History HistoryConfig `yaml:"history"`
+
+	Silences SilencesConfig `yaml:"silences"`
+
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+
+	Digests []DigestConfig `yaml:"digests"`
+
+	Redaction RedactionConfig `yaml:"redaction"`
+
+	Severity  SeverityConfig  `yaml:"severity"`
+	PagerDuty PagerDutyConfig `yaml:"pagerduty"`
+
+	// OnCall are rotations referred to by alarms and groups.
+	OnCall map[string]OnCallConfig `yaml:"on_call"`
+
+	// HTTP is read at startup only.
+	HTTP struct {
+		// Listen enables the HTTP API, e.g. ":8080".
+		Listen   string `yaml:"listen"`
+		ApiToken string `yaml:"api_token"`
+		// SlackSigningSecret enables the Slack endpoints, verifying requests
+		// with the signing secret of the Slack app.
+		SlackSigningSecret string `yaml:"slack_signing_secret"`
+	} `yaml:"http"`
+
+	Slack     SlackConfig         `yaml:"slack"`
+	Templates TemplateConfig      `yaml:"templates"`
+	Fields    FieldsConfig        `yaml:"fields"`
+	Alarms    map[AlarmName]Alarm `yaml:"alarms"`
+}
+
+// RetryConfig decides when a message that keeps failing is given up.
+type RetryConfig struct {
+	// MaxReceives is the receive count at which a transient failure is given up.
+	MaxReceives *int `yaml:"max_receives"`
+	// DeadLetterSqsURL receives given up messages with the error as message attributes.
+	DeadLetterSqsURL string `yaml:"dead_letter_sqs_url"`
+	// OpsSlack is merged over the global slack config to report given up messages.
+	OpsSlack SlackConfig `yaml:"ops_slack"`
+}
+
+// StateConfig selects where state such as delivered notifications is kept.
+// It is read at startup only.
+type StateConfig struct {
+	// Type is one of "memory" (default), "file" or "dynamodb".
+	Type     string `yaml:"type"`
+	Path     string `yaml:"path"`
+	DynamoDB struct {
+		Table  string `yaml:"table"`
+		Region string `yaml:"region"`
+		// Endpoint overrides the DynamoDB endpoint, e.g. for DynamoDB Local.
+		Endpoint string `yaml:"endpoint"`
+	} `yaml:"dynamodb"`
+}
+
+// RateLimitConfig limits notifications per destination with a token bucket.
+// Notifications over the limit are posted together as one message per interval.
+type RateLimitConfig struct {
+	// PerMinute enables rate limiting when set.
+	PerMinute *float64 `yaml:"per_minute"`
+	Burst     *int     `yaml:"burst"`
+	// Interval is how often aggregated messages are posted, in seconds.
+	Interval *int64 `yaml:"interval"`
+}
+
+func (c RateLimitConfig) GetBurst() int {
+	if c.Burst == nil {
+		return 5
+	}
+	return *c.Burst
+}
+
+func (c RateLimitConfig) GetInterval() int64 {
+	if c.Interval == nil {
+		return 300
+	}
+	return *c.Interval
+}
+
+// DigestConfig posts a periodic report of the alarms of each channel.
+type DigestConfig struct {
+	// Schedule is a standard 5-field cron expression evaluated in Timezone.
+	Schedule string `yaml:"schedule"`
+	Timezone string `yaml:"timezone"`
+	// Period is how far back the report looks in seconds.
+	Period *int64 `yaml:"period"`
+	// Channels limits the report to these channels, all channels by default.
+	Channels []string `yaml:"channels"`
+	// Top is how many of the most frequent errors are listed.
+	Top *int `yaml:"top"`
+	// Slack is merged over the global slack config, except for the channel.
+	// Without an api_token the token of the alarm counted is used.
+	Slack SlackConfig `yaml:"slack"`
+}
+
+func (c DigestConfig) GetPeriod() int64 {
+	if c.Period == nil {
+		return 24 * 60 * 60
+	}
+	return *c.Period
+}
+
+func (c DigestConfig) GetTop() int {
+	if c.Top == nil {
+		return 10
+	}
+	return *c.Top
+}
+
+// RedactionConfig removes secrets and personal data from log messages before
+// they are notified.
+type RedactionConfig struct {
+	Disabled bool `yaml:"disabled"`
+	// Detectors are the built-in detectors to use, all of them by default.
+	Detectors []string `yaml:"detectors"`
+	Patterns  []struct {
+		Name  string `yaml:"name"`
+		Regex string `yaml:"regex"`
+	} `yaml:"patterns"`
+	// JSONKeys are JSON object keys whose values are replaced as a whole.
+	JSONKeys []string `yaml:"json_keys"`
+}
+
+var defaultRedactionJSONKeys = []string{
+	"password", "passwd", "secret", "token", "access_token", "refresh_token",
+	"api_key", "apikey", "authorization", "cookie", "set-cookie",
+}
+
+// Redactor builds the redactor of the config. c must be valid.
+func (c RedactionConfig) Redactor() *redact.Redactor {
+	if c.Disabled {
+		return nil
+	}
+
+	builtin := redact.Builtin()
+	names := c.Detectors
+	if names == nil {
+		for name := range builtin {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	var rules []redact.Rule
+	for _, name := range names {
+		rules = append(rules, builtin[name])
+	}
+	for _, p := range c.Patterns {
+		rules = append(rules, redact.Rule{
+			Name:    p.Name,
+			Pattern: regexp.MustCompile(p.Regex),
+		})
+	}
+
+	keys := c.JSONKeys
+	if keys == nil {
+		keys = defaultRedactionJSONKeys
+	}
+	return redact.New(rules, keys)
+}
+
+// HistoryConfig keeps a record of every processed alarm message.
+type HistoryConfig struct {
+	// RetentionDays is how long records are kept, 30 days by default.
+	RetentionDays *int `yaml:"retention_days"`
+}
+
+func (c HistoryConfig) GetRetention() time.Duration {
+	days := 30
+	if c.RetentionDays != nil {
+		days = *c.RetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+type SilencesConfig struct {
+	// SummarySlack is merged over the global slack config to post a summary
+	// when a silence expires.
+	SummarySlack SlackConfig       `yaml:"summary_slack"`
+	Rules        []silence.Silence `yaml:"rules"`
+}
+
+func (c RetryConfig) GetMaxReceives() int {
+	if c.MaxReceives == nil {
+		return 5
+	}
+	return *c.MaxReceives
 }
 
 type SlackConfig struct {
@@ -55,29 +265,340 @@ func (c *SlackConfig) Merge(sc SlackConfig) {
 	}
 }
 
+// TemplateConfig holds Go templates of notifications, see the render package.
+// Empty templates fall back to the defaults.
+type TemplateConfig struct {
+	Title string `yaml:"title"`
+	Body  string `yaml:"body"`
+	Event string `yaml:"event"`
+}
+
+func (c *TemplateConfig) Merge(tc TemplateConfig) {
+	if tc.Title != "" {
+		c.Title = tc.Title
+	}
+	if tc.Body != "" {
+		c.Body = tc.Body
+	}
+	if tc.Event != "" {
+		c.Event = tc.Event
+	}
+}
+
+// Compile parses the templates.
+func (c TemplateConfig) Compile() (*render.Templates, error) {
+	return render.Compile(c.Title, c.Body, c.Event)
+}
+
+// FieldsConfig maps JSON paths such as "error.stack" of structured logs to the
+// parts of their summary. Unset lists fall back to the defaults of Mapping.
+type FieldsConfig struct {
+	Message []string `yaml:"message"`
+	Level   []string `yaml:"level"`
+	Stack   []string `yaml:"stack"`
+	// Context are paths shown as key/value pairs, e.g. request_id.
+	Context []string `yaml:"context"`
+}
+
+func (c *FieldsConfig) Merge(fc FieldsConfig) {
+	if fc.Message != nil {
+		c.Message = fc.Message
+	}
+	if fc.Level != nil {
+		c.Level = fc.Level
+	}
+	if fc.Stack != nil {
+		c.Stack = fc.Stack
+	}
+	if fc.Context != nil {
+		c.Context = fc.Context
+	}
+}
+
+// Mapping returns the field mapping with defaults for unset lists.
+func (c FieldsConfig) Mapping() render.FieldMapping {
+	m := render.FieldMapping{
+		Message: c.Message,
+		Level:   c.Level,
+		Stack:   c.Stack,
+		Context: c.Context,
+	}
+	if m.Message == nil {
+		m.Message = []string{"message", "msg"}
+	}
+	if m.Level == nil {
+		m.Level = []string{"level", "severity", "log_level"}
+	}
+	if m.Stack == nil {
+		m.Stack = []string{"error.stack", "stack", "stack_trace", "exception"}
+	}
+	return m
+}
+
+// MultilineConfig reassembles records such as stack traces that are logged one
+// line per event, by fetching the lines around a matched event from its log
+// stream. It is enabled by Preset or Continuation.
+type MultilineConfig struct {
+	// Preset is a built-in rule of the multiline package, "java" or "python".
+	Preset string `yaml:"preset"`
+	// Start and Continuation are regular expressions overriding the preset.
+	Start        string `yaml:"start"`
+	Continuation string `yaml:"continuation"`
+	// MaxLines is the maximum number of lines of a record.
+	MaxLines *int `yaml:"max_lines"`
+}
+
+func (c MultilineConfig) Enabled() bool {
+	return c.Preset != "" || c.Continuation != ""
+}
+
+func (c MultilineConfig) GetMaxLines() int {
+	if c.MaxLines == nil {
+		return 200
+	}
+	return *c.MaxLines
+}
+
+// Rule builds the multiline rule of the config. c must be valid.
+func (c MultilineConfig) Rule() multiline.Rule {
+	rule := multiline.Presets()[c.Preset]
+	if c.Start != "" {
+		rule.Start = regexp.MustCompile(c.Start)
+	}
+	if c.Continuation != "" {
+		rule.Continuation = regexp.MustCompile(c.Continuation)
+	}
+	return rule
+}
+
 type Alarm struct {
-	SqsURL string      `yaml:"sqs_url"`
+	SqsURL    string          `yaml:"sqs_url"`
+	Receive   ReceiveConfig   `yaml:"receive"`
+	Slack     SlackConfig     `yaml:"slack"`
+	Templates TemplateConfig  `yaml:"templates"`
+	Fields    FieldsConfig    `yaml:"fields"`
+	Multiline MultilineConfig `yaml:"multiline"`
+	Groups    []AlarmGroup    `yaml:"groups"`
+
+	// Mentions are Slack users and user groups to mention: raw mentions such as
+	// "<!subteam^ID>", user ids, emails resolved with users.lookupByEmail, or
+	// "@here" and "@channel".
+	Mentions []string `yaml:"mentions"`
+	// OnCall is the name of the rotation whose current user is mentioned.
+	OnCall string `yaml:"on_call"`
+
+	MaintenanceWindows []Window `yaml:"maintenance_windows"`
+	QuietHours         []Window `yaml:"quiet_hours"`
+}
+
+// ReceiveConfig controls how messages are received from the alarm queue.
+// Unset values fall back to the defaults of the Get* methods.
+type ReceiveConfig struct {
+	Concurrency     *int   `yaml:"concurrency"`
+	MaxMessages     *int64 `yaml:"max_messages"`
+	WaitTimeSeconds *int64 `yaml:"wait_time_seconds"`
+	Ordered         bool   `yaml:"ordered"`
+
+	// VisibilityTimeout is kept extended from receive until a message is handled. 0 disables it.
+	VisibilityTimeout *int64 `yaml:"visibility_timeout"`
+}
+
+func (c ReceiveConfig) GetConcurrency() int {
+	if c.Concurrency == nil {
+		return 4
+	}
+	return *c.Concurrency
+}
+
+func (c ReceiveConfig) GetMaxMessages() int64 {
+	if c.MaxMessages == nil {
+		return 10
+	}
+	return *c.MaxMessages
+}
+
+func (c ReceiveConfig) GetVisibilityTimeout() int64 {
+	if c.VisibilityTimeout == nil {
+		return 60
+	}
+	return *c.VisibilityTimeout
+}
+
+func (c ReceiveConfig) GetWaitTimeSeconds() int64 {
+	if c.WaitTimeSeconds == nil {
+		return 20
+	}
+	return *c.WaitTimeSeconds
+}
+
+type AlarmGroup struct {
+	Slack                  SlackConfig `yaml:"slack"`
+	LogGroups              []string    `yaml:"log_groups"`
+	AWSBatchJobDefinitions []string    `yaml:"awsbatch_job_definitions"`
+
+	Templates TemplateConfig  `yaml:"templates"`
+	Fields    FieldsConfig    `yaml:"fields"`
+	Multiline MultilineConfig `yaml:"multiline"`
+	// ContextBefore and ContextAfter are how many lines of the log stream
+	// around each matched event are notified with it.
+	ContextBefore int `yaml:"context_before"`
+	ContextAfter  int `yaml:"context_after"`
+
+	// Mentions are added to those of the alarm, and OnCall overrides the alarm.
+	Mentions []string `yaml:"mentions"`
+	OnCall   string   `yaml:"on_call"`
+
+	MaintenanceWindows []Window `yaml:"maintenance_windows"`
+	QuietHours         []Window `yaml:"quiet_hours"`
+}
+
+const (
+	WindowActionSuppress = "suppress"
+	WindowActionDigest   = "digest"
+	WindowActionRoute    = "route"
+)
+
+// Window changes how notifications are delivered while it is open.
+type Window struct {
+	window.Window `yaml:",inline"`
+
+	// Action is "suppress" (default), "digest" to post one summary when the
+	// window closes, or "route" to post to Slack merged over the usual settings.
+	Action string      `yaml:"action"`
 	Slack  SlackConfig `yaml:"slack"`
-	Groups []struct {
-		Slack                  SlackConfig `yaml:"slack"`
-		LogGroups              []string    `yaml:"log_groups"`
-		AWSBatchJobDefinitions []string    `yaml:"awsbatch_job_definitions"`
-	} `yaml:"groups"`
+}
+
+func (w *Window) GetAction() string {
+	if w.Action == "" {
+		return WindowActionSuppress
+	}
+	return w.Action
+}
+
+// Windows returns the windows that apply to events matched by group, group
+// windows first. A nil group yields the windows of the alarm only.
+func (a *Alarm) Windows(group *AlarmGroup) []Window {
+	var windows []Window
+	if group != nil {
+		windows = append(windows, group.MaintenanceWindows...)
+		windows = append(windows, group.QuietHours...)
+	}
+	windows = append(windows, a.MaintenanceWindows...)
+	windows = append(windows, a.QuietHours...)
+	return windows
+}
+
+// ActiveWindow returns the first of windows open at t and when it closes.
+func ActiveWindow(windows []Window, t time.Time) (*Window, time.Time) {
+	for i := range windows {
+		if end, ok := windows[i].ActiveUntil(t); ok {
+			return &windows[i], end
+		}
+	}
+	return nil, time.Time{}
+}
+
+// EffectiveSlack returns the Slack settings used for events matched by group.
+// A nil group yields the settings used when no group matches.
+func (c *Config) EffectiveSlack(alarm Alarm, group *AlarmGroup) SlackConfig {
+	slack := c.Slack
+	if group != nil {
+		slack.Merge(alarm.Slack)
+		slack.Merge(group.Slack)
+	}
+	return slack
+}
+
+// EffectiveTemplates returns the templates used for events matched by group,
+// merged from the global, alarm and group templates. A nil group yields the
+// templates of the alarm.
+func (c *Config) EffectiveTemplates(alarm Alarm, group *AlarmGroup) TemplateConfig {
+	templates := c.Templates
+	templates.Merge(alarm.Templates)
+	if group != nil {
+		templates.Merge(group.Templates)
+	}
+	return templates
+}
+
+// EffectiveFields returns the field mapping used for events matched by group,
+// merged like EffectiveTemplates.
+func (c *Config) EffectiveFields(alarm Alarm, group *AlarmGroup) FieldsConfig {
+	fields := c.Fields
+	fields.Merge(alarm.Fields)
+	if group != nil {
+		fields.Merge(group.Fields)
+	}
+	return fields
+}
+
+// EffectiveMultiline returns the multiline config of group when it is enabled,
+// or else the one of the alarm.
+func (c *Config) EffectiveMultiline(alarm Alarm, group *AlarmGroup) MultilineConfig {
+	if group != nil && group.Multiline.Enabled() {
+		return group.Multiline
+	}
+	return alarm.Multiline
 }
 
 func Load(filename string) error {
+	conf, err := Parse(filename)
+	if err != nil {
+		return err
+	}
+
+	current.Store(conf)
+	return nil
+}
+
+// Parse reads filename strictly, expands environment variables and secret
+// references and validates it without replacing the loaded config.
+func Parse(filename string) (*Config, error) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
-	if err := yaml.Unmarshal(data, &c); err != nil {
-		return errors.WithStack(err)
+	var conf Config
+	var decodeProblems []string
+	if err := yaml.UnmarshalStrict(data, &conf); err != nil {
+		terr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return nil, errors.WithStack(err)
+		}
+		// 未知のフィールドや型の誤りも検証の問題とまとめて報告する
+		decodeProblems = terr.Errors
 	}
 
-	return nil
+	if err := expandEnv(&conf); err != nil {
+		return nil, err
+	}
+
+	if err := conf.resolveSecrets(); err != nil {
+		return nil, err
+	}
+
+	err = conf.Validate()
+	if len(decodeProblems) > 0 {
+		verr := &ValidationError{Problems: decodeProblems}
+		if v, ok := err.(*ValidationError); ok {
+			verr.Problems = append(verr.Problems, v.Problems...)
+		}
+		err = verr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &conf, nil
 }
 
+// Get returns the current config snapshot. Load replaces the snapshot instead of
+// modifying it, so callers must treat the returned config as read-only and should
+// keep using the same snapshot for the whole of a unit of work.
 func Get() *Config {
-	return &c
+	if conf, ok := current.Load().(*Config); ok {
+		return conf
+	}
+	return &Config{}
 }
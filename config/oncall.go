@@ -0,0 +1,115 @@
+package config
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// onCallStartLayout is the layout of OnCallConfig.Start.
+const onCallStartLayout = "2006-01-02T15:04"
+
+// OnCallConfig is a rotation where each of Users is on call for one period in
+// turn, a week by default.
+type OnCallConfig struct {
+	// Start is when the first user goes on call, e.g. "2024-01-01T10:00" in
+	// Timezone. Later handoffs happen at the same time every period.
+	Start    string `yaml:"start"`
+	Timezone string `yaml:"timezone"`
+	// Period is the length of a shift in seconds.
+	Period *int64 `yaml:"period"`
+	// Users are Slack mentions, see Mentions.
+	Users []string `yaml:"users"`
+}
+
+func (c OnCallConfig) GetPeriod() int64 {
+	if c.Period == nil {
+		return 7 * 24 * 60 * 60
+	}
+	return *c.Period
+}
+
+func (c OnCallConfig) start() (time.Time, error) {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "invalid timezone %q", c.Timezone)
+	}
+	start, err := time.ParseInLocation(onCallStartLayout, c.Start, loc)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "invalid start %q", c.Start)
+	}
+	return start, nil
+}
+
+// Validate checks that the rotation can be evaluated.
+func (c OnCallConfig) Validate() error {
+	if len(c.Users) == 0 {
+		return errors.New("users must not be empty")
+	}
+	if c.GetPeriod() <= 0 {
+		return errors.New("period must be positive")
+	}
+	_, err := c.start()
+	return err
+}
+
+// Current returns the user on call at t. c must be valid. Periods of whole
+// days hand off at the local time of Start even across DST changes.
+func (c OnCallConfig) Current(t time.Time) string {
+	start, err := c.start()
+	if err != nil {
+		return ""
+	}
+	period := time.Duration(c.GetPeriod()) * time.Second
+	var shifts int64
+	if period%(24*time.Hour) == 0 {
+		shifts = floorDiv(elapsedDays(start, t), int64(period/(24*time.Hour)))
+	} else {
+		shifts = floorDiv(int64(t.Sub(start)), int64(period))
+	}
+	n := int64(len(c.Users))
+	return c.Users[((shifts%n)+n)%n]
+}
+
+// elapsedDays returns how many whole days have passed from start to t, where
+// days turn over at the local time of start in its location.
+func elapsedDays(start, t time.Time) int64 {
+	t = t.In(start.Location())
+	day := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	days := int64(day(t).Sub(day(start)) / (24 * time.Hour))
+	handoff := time.Date(t.Year(), t.Month(), t.Day(), start.Hour(), start.Minute(), 0, 0, start.Location())
+	if t.Before(handoff) {
+		days--
+	}
+	return days
+}
+
+// floorDiv divides rounding toward negative infinity, so that times before
+// the start count back from the last user.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b < 0 {
+		q--
+	}
+	return q
+}
+
+// Mentions returns the Slack mentions for events matched by group: those of
+// the alarm, of the group and the current on-call user of the group, or else
+// of the alarm.
+func (c *Config) Mentions(alarm Alarm, group *AlarmGroup, t time.Time) []string {
+	mentions := append([]string{}, alarm.Mentions...)
+	onCall := alarm.OnCall
+	if group != nil {
+		mentions = append(mentions, group.Mentions...)
+		if group.OnCall != "" {
+			onCall = group.OnCall
+		}
+	}
+	if oc, ok := c.OnCall[onCall]; ok {
+		mentions = append(mentions, oc.Current(t))
+	}
+	return mentions
+}
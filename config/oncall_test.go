@@ -0,0 +1,69 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOnCallCurrent(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	period := func(sec int64) *int64 { return &sec }
+
+	// 2026-01-05 is a Monday.
+	weekly := OnCallConfig{Start: "2026-01-05T10:00", Timezone: "Asia/Tokyo", Users: []string{"a", "b", "c"}}
+	halfDay := weekly
+	halfDay.Period = period(12 * 60 * 60)
+	daily := weekly
+	daily.Period = period(24 * 60 * 60)
+	single := weekly
+	single.Users = []string{"a"}
+	// 2026-03-08 02:00 EST jumps to 03:00 EDT, and 2026-11-01 02:00 EDT falls back to 01:00 EST.
+	spring := OnCallConfig{Start: "2026-03-02T10:00", Timezone: "America/New_York", Users: []string{"a", "b"}}
+	fall := OnCallConfig{Start: "2026-10-26T10:00", Timezone: "America/New_York", Users: []string{"a", "b"}}
+	springDaily := spring
+	springDaily.Period = period(24 * 60 * 60)
+
+	tests := []struct {
+		name string
+		c    OnCallConfig
+		at   time.Time
+		want string
+	}{
+		{"at start", weekly, time.Date(2026, 1, 5, 10, 0, 0, 0, tokyo), "a"},
+		{"just before the handoff", weekly, time.Date(2026, 1, 12, 9, 59, 59, 0, tokyo), "a"},
+		{"at the handoff", weekly, time.Date(2026, 1, 12, 10, 0, 0, 0, tokyo), "b"},
+		{"after a full rotation", weekly, time.Date(2026, 1, 26, 10, 0, 0, 0, tokyo), "a"},
+		{"in another timezone", weekly, time.Date(2026, 1, 12, 1, 0, 0, 0, time.UTC), "b"},
+		{"just before start", weekly, time.Date(2026, 1, 5, 9, 59, 0, 0, tokyo), "c"},
+		{"a period before start", weekly, time.Date(2025, 12, 29, 10, 0, 0, 0, tokyo), "c"},
+		{"more than a period before start", weekly, time.Date(2025, 12, 29, 9, 59, 0, 0, tokyo), "b"},
+		{"half day period", halfDay, time.Date(2026, 1, 5, 22, 0, 0, 0, tokyo), "b"},
+		{"half day period next day", halfDay, time.Date(2026, 1, 6, 10, 0, 0, 0, tokyo), "c"},
+		{"half day period before start", halfDay, time.Date(2026, 1, 5, 9, 0, 0, 0, tokyo), "c"},
+		{"daily period", daily, time.Date(2026, 1, 7, 10, 0, 0, 0, tokyo), "c"},
+		{"daily period before the handoff", daily, time.Date(2026, 1, 7, 9, 0, 0, 0, tokyo), "b"},
+		{"single user", single, time.Date(2026, 3, 1, 0, 0, 0, 0, tokyo), "a"},
+		{"single user before start", single, time.Date(2025, 3, 1, 0, 0, 0, 0, tokyo), "a"},
+		{"before the handoff after DST starts", spring, time.Date(2026, 3, 9, 9, 59, 0, 0, ny), "a"},
+		{"at the handoff after DST starts", spring, time.Date(2026, 3, 9, 10, 0, 0, 0, ny), "b"},
+		{"daily across DST start", springDaily, time.Date(2026, 3, 8, 10, 0, 0, 0, ny), "a"},
+		{"daily before the handoff on DST start", springDaily, time.Date(2026, 3, 8, 9, 30, 0, 0, ny), "b"},
+		{"before the handoff after DST ends", fall, time.Date(2026, 11, 2, 9, 59, 0, 0, ny), "a"},
+		{"at the handoff after DST ends", fall, time.Date(2026, 11, 2, 10, 0, 0, 0, ny), "b"},
+	}
+	for _, tt := range tests {
+		if err := tt.c.Validate(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := tt.c.Current(tt.at); got != tt.want {
+			t.Errorf("%s: Current(%s) = %q, want %q", tt.name, tt.at, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/pkg/errors"
+)
+
+// SecretResolver resolves the part of a secret reference after "<scheme>:".
+type SecretResolver interface {
+	Resolve(ref string) (string, error)
+}
+
+type SecretResolverFunc func(ref string) (string, error)
+
+func (f SecretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+var (
+	resolversMu sync.RWMutex
+	resolvers   = map[string]SecretResolver{
+		"file": SecretResolverFunc(resolveFile),
+		"ssm": SecretResolverFunc(func(ref string) (string, error) {
+			return NewSSMResolver(ssm.New(awsSession())).Resolve(ref)
+		}),
+		"secretsmanager": SecretResolverFunc(func(ref string) (string, error) {
+			return NewSecretsManagerResolver(secretsmanager.New(awsSession())).Resolve(ref)
+		}),
+	}
+
+	sessOnce sync.Once
+	sess     *session.Session
+)
+
+func awsSession() *session.Session {
+	sessOnce.Do(func() {
+		sess = session.Must(session.NewSession())
+	})
+	return sess
+}
+
+// RegisterSecretResolver makes references of the form "<scheme>:<ref>" resolvable,
+// replacing any resolver already registered for scheme.
+func RegisterSecretResolver(scheme string, r SecretResolver) {
+	resolversMu.Lock()
+	defer resolversMu.Unlock()
+	resolvers[scheme] = r
+}
+
+// ResolveSecret returns the value referenced by v if it starts with a registered
+// scheme, and v unchanged otherwise.
+func ResolveSecret(v string) (string, error) {
+	i := strings.Index(v, ":")
+	if i <= 0 {
+		return v, nil
+	}
+
+	resolversMu.RLock()
+	r, ok := resolvers[v[:i]]
+	resolversMu.RUnlock()
+	if !ok {
+		return v, nil
+	}
+
+	resolved, err := r.Resolve(v[i+1:])
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve secret %q", v)
+	}
+	return resolved, nil
+}
+
+func resolveFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+type ssmResolver struct {
+	api ssmiface.SSMAPI
+}
+
+// NewSSMResolver resolves "ssm:/parameter/name" references with decryption.
+func NewSSMResolver(api ssmiface.SSMAPI) SecretResolver {
+	return &ssmResolver{api: api}
+}
+
+func (r *ssmResolver) Resolve(name string) (string, error) {
+	out, err := r.api.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return aws.StringValue(out.Parameter.Value), nil
+}
+
+type secretsManagerResolver struct {
+	api secretsmanageriface.SecretsManagerAPI
+}
+
+// NewSecretsManagerResolver resolves "secretsmanager:name" references.
+// "secretsmanager:name#key" selects key from a JSON secret.
+func NewSecretsManagerResolver(api secretsmanageriface.SecretsManagerAPI) SecretResolver {
+	return &secretsManagerResolver{api: api}
+}
+
+func (r *secretsManagerResolver) Resolve(ref string) (string, error) {
+	name, key := ref, ""
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		name, key = ref[:i], ref[i+1:]
+	}
+
+	out, err := r.api.GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	value := aws.StringValue(out.SecretString)
+	if key == "" {
+		return value, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", errors.Wrapf(err, "secret %s is not a JSON object", name)
+	}
+	v, ok := fields[key]
+	if !ok {
+		return "", errors.Errorf("secret %s has no key %s", name, key)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", errors.Errorf("secret %s key %s is not a string", name, key)
+	}
+	return s, nil
+}
+
+var envRefPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces ${VAR} and ${VAR:-default} in the string values reachable
+// from v, which must be a pointer, with environment values. It runs on the parsed
+// config so that values are never spliced into the YAML document.
+func expandEnv(v interface{}) error {
+	var missing []string
+	expandValue(reflect.ValueOf(v), &missing)
+	if len(missing) > 0 {
+		return errors.Errorf("environment variables not set: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func expandValue(v reflect.Value, missing *[]string) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			expandValue(v.Elem(), missing)
+		}
+	case reflect.Interface:
+		if v.IsNil() || !v.CanSet() {
+			return
+		}
+		e := reflect.New(v.Elem().Type()).Elem()
+		e.Set(v.Elem())
+		expandValue(e, missing)
+		v.Set(e)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			// 非公開のフィールドはYAMLから読まれないので対象外
+			if f := v.Field(i); f.CanSet() {
+				expandValue(f, missing)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			expandValue(v.Index(i), missing)
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			expandValue(e, missing)
+			v.SetMapIndex(k, e)
+		}
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(expandString(v.String(), missing))
+		}
+	}
+}
+
+func expandString(s string, missing *[]string) string {
+	return envRefPattern.ReplaceAllStringFunc(s, func(m string) string {
+		sub := envRefPattern.FindStringSubmatch(m)
+		if v, ok := os.LookupEnv(sub[1]); ok {
+			return v
+		}
+		if sub[2] != "" {
+			return sub[3]
+		}
+		*missing = append(*missing, sub[1])
+		return m
+	})
+}
+
+// resolveSecrets replaces secret references in c with their values.
+func (c *Config) resolveSecrets() error {
+	if err := c.Slack.resolveSecrets(); err != nil {
+		return err
+	}
+	token, err := ResolveSecret(c.HTTP.ApiToken)
+	if err != nil {
+		return err
+	}
+	c.HTTP.ApiToken = token
+	if c.HTTP.SlackSigningSecret, err = ResolveSecret(c.HTTP.SlackSigningSecret); err != nil {
+		return err
+	}
+
+	if err := c.Retry.OpsSlack.resolveSecrets(); err != nil {
+		return err
+	}
+	if err := c.Silences.SummarySlack.resolveSecrets(); err != nil {
+		return err
+	}
+	if c.PagerDuty.RoutingKey, err = ResolveSecret(c.PagerDuty.RoutingKey); err != nil {
+		return err
+	}
+	for severity, l := range c.Severity.Levels {
+		if err := l.Slack.resolveSecrets(); err != nil {
+			return err
+		}
+		if l.PagerDuty.RoutingKey, err = ResolveSecret(l.PagerDuty.RoutingKey); err != nil {
+			return err
+		}
+		c.Severity.Levels[severity] = l
+	}
+	for i := range c.Digests {
+		if err := c.Digests[i].Slack.resolveSecrets(); err != nil {
+			return err
+		}
+	}
+	for name, alarm := range c.Alarms {
+		if err := alarm.Slack.resolveSecrets(); err != nil {
+			return err
+		}
+		if err := resolveWindowSecrets(alarm.MaintenanceWindows, alarm.QuietHours); err != nil {
+			return err
+		}
+		for i := range alarm.Groups {
+			g := &alarm.Groups[i]
+			if err := g.Slack.resolveSecrets(); err != nil {
+				return err
+			}
+			if err := resolveWindowSecrets(g.MaintenanceWindows, g.QuietHours); err != nil {
+				return err
+			}
+		}
+		c.Alarms[name] = alarm
+	}
+	return nil
+}
+
+func resolveWindowSecrets(lists ...[]Window) error {
+	for _, windows := range lists {
+		for i := range windows {
+			if err := windows[i].Slack.resolveSecrets(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *SlackConfig) resolveSecrets() error {
+	v, err := ResolveSecret(c.ApiToken)
+	if err != nil {
+		return err
+	}
+	c.ApiToken = v
+	return nil
+}
@@ -0,0 +1,257 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+type stubSSM struct {
+	ssmiface.SSMAPI
+	params map[string]string
+}
+
+func (s *stubSSM) GetParameter(in *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	if !aws.BoolValue(in.WithDecryption) {
+		return nil, awserr.New("ValidationException", "decryption required", nil)
+	}
+	v, ok := s.params[aws.StringValue(in.Name)]
+	if !ok {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "not found", nil)
+	}
+	return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(v)}}, nil
+}
+
+type stubSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	secrets map[string]string
+}
+
+func (s *stubSecretsManager) GetSecretValue(in *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	v, ok := s.secrets[aws.StringValue(in.SecretId)]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "not found", nil)
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(v)}, nil
+}
+
+// stubSecretResolver registers r for scheme and returns a function restoring
+// the previous resolver.
+func stubSecretResolver(t *testing.T, scheme string, r SecretResolver) func() {
+	t.Helper()
+	resolversMu.Lock()
+	prev, ok := resolvers[scheme]
+	resolversMu.Unlock()
+
+	RegisterSecretResolver(scheme, r)
+	return func() {
+		resolversMu.Lock()
+		defer resolversMu.Unlock()
+		if ok {
+			resolvers[scheme] = prev
+		} else {
+			delete(resolvers, scheme)
+		}
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("CWL_TEST_TOKEN", "xoxb-1#2: 3\nx")
+	os.Setenv("CWL_TEST_EMPTY", "")
+	defer os.Unsetenv("CWL_TEST_TOKEN")
+	defer os.Unsetenv("CWL_TEST_EMPTY")
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "plain", want: "plain"},
+		{in: "${CWL_TEST_TOKEN}", want: "xoxb-1#2: 3\nx"},
+		{in: "a-${CWL_TEST_EMPTY}-b", want: "a--b"},
+		{in: "${CWL_TEST_UNSET:-fallback}", want: "fallback"},
+		{in: "${CWL_TEST_UNSET:-}", want: ""},
+		{in: "${CWL_TEST_EMPTY:-fallback}", want: ""},
+		{in: "$CWL_TEST_TOKEN", want: "$CWL_TEST_TOKEN"},
+		{in: "${CWL_TEST_UNSET}", wantErr: true},
+	}
+	for _, tt := range tests {
+		c := Config{Slack: SlackConfig{ApiToken: tt.in}}
+		err := expandEnv(&c)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("expandEnv(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && c.Slack.ApiToken != tt.want {
+			t.Errorf("expandEnv(%q) = %q, want %q", tt.in, c.Slack.ApiToken, tt.want)
+		}
+	}
+}
+
+func TestExpandEnvNested(t *testing.T) {
+	os.Setenv("CWL_TEST_CHANNEL", "#alerts")
+	defer os.Unsetenv("CWL_TEST_CHANNEL")
+
+	c := Config{
+		Alarms: map[AlarmName]Alarm{
+			"a": {
+				Groups:   []AlarmGroup{{Slack: SlackConfig{Channel: "${CWL_TEST_CHANNEL}"}}},
+				Mentions: []string{"${CWL_TEST_CHANNEL}"},
+			},
+		},
+		OnCall: map[string]OnCallConfig{"ops": {Users: []string{"${CWL_TEST_CHANNEL}"}}},
+	}
+	if err := expandEnv(&c); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Alarms["a"].Groups[0].Slack.Channel; got != "#alerts" {
+		t.Errorf("group channel = %q", got)
+	}
+	if got := c.Alarms["a"].Mentions[0]; got != "#alerts" {
+		t.Errorf("alarm mention = %q", got)
+	}
+	if got := c.OnCall["ops"].Users[0]; got != "#alerts" {
+		t.Errorf("on-call user = %q", got)
+	}
+}
+
+func TestParseExpandsEnvInValuesOnly(t *testing.T) {
+	os.Setenv("CWL_TEST_TOKEN", "xoxb-1 # not a comment: really")
+	defer os.Unsetenv("CWL_TEST_TOKEN")
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yml")
+	data := `# token is read from ${CWL_TEST_UNSET}
+aws:
+  region: ap-northeast-1
+slack:
+  api_token: ${CWL_TEST_TOKEN}
+  channel: "#a"
+alarms:
+  a:
+    sqs_url: https://sqs.ap-northeast-1.amazonaws.com/123456789012/a
+`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Slack.ApiToken, "xoxb-1 # not a comment: really"; got != want {
+		t.Errorf("api_token = %q, want %q", got, want)
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(path, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	defer stubSecretResolver(t, "ssm", NewSSMResolver(&stubSSM{params: map[string]string{"/app/token": "from-ssm"}}))()
+	defer stubSecretResolver(t, "secretsmanager", NewSecretsManagerResolver(&stubSecretsManager{
+		secrets: map[string]string{"app": `{"token":"from-sm"}`},
+	}))()
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "xoxb-plain", want: "xoxb-plain"},
+		{in: "https://example.com/x", want: "https://example.com/x"},
+		{in: ":no-scheme", want: ":no-scheme"},
+		{in: "file:" + path, want: "from-file"},
+		{in: "file:" + filepath.Join(dir, "missing"), wantErr: true},
+		{in: "ssm:/app/token", want: "from-ssm"},
+		{in: "ssm:/app/missing", wantErr: true},
+		{in: "secretsmanager:app#token", want: "from-sm"},
+		{in: "secretsmanager:missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ResolveSecret(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ResolveSecret(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ResolveSecret(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSSMResolver(t *testing.T) {
+	r := NewSSMResolver(&stubSSM{params: map[string]string{"/app/token": "secret"}})
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "/app/token", want: "secret"},
+		{name: "/app/missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := r.Resolve(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Resolve(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSecretsManagerResolver(t *testing.T) {
+	r := NewSecretsManagerResolver(&stubSecretsManager{secrets: map[string]string{
+		"plain":         "secret",
+		"json":          `{"token":"t","port":8080}`,
+		"name#with":     "hash",
+		"not-an-object": `["a"]`,
+	}})
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{ref: "plain", want: "secret"},
+		{ref: "json", want: `{"token":"t","port":8080}`},
+		{ref: "json#token", want: "t"},
+		{ref: "name#with#", want: "hash"},
+		{ref: "json#missing", wantErr: true},
+		{ref: "json#port", wantErr: true},
+		{ref: "not-an-object#token", wantErr: true},
+		{ref: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := r.Resolve(tt.ref)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Resolve(%q) error = %v, wantErr %v", tt.ref, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
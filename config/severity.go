@@ -0,0 +1,177 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// Severities are the severities from the highest.
+var Severities = []string{SeverityCritical, SeverityError, SeverityWarning, SeverityInfo}
+
+const (
+	SinkSlack     = "slack"
+	SinkPagerDuty = "pagerduty"
+)
+
+const (
+	DeliveryImmediate = "immediate"
+	DeliveryDigest    = "digest"
+)
+
+// SeverityConfig derives the severity of a notification from the alarm and the
+// logs, and decides how notifications of each severity are delivered.
+type SeverityConfig struct {
+	// Default is the severity when no rule matches, "error" by default.
+	Default string `yaml:"default"`
+	// Rules are evaluated in order and the first matching rule wins.
+	Rules  []SeverityRule           `yaml:"rules"`
+	Levels map[string]SeverityLevel `yaml:"levels"`
+}
+
+// SeverityRule matches when all of its conditions hold. A rule without
+// conditions always matches.
+type SeverityRule struct {
+	Severity string `yaml:"severity"`
+	// AlarmNames are glob patterns of the alarm name.
+	AlarmNames []string `yaml:"alarm_names"`
+	// AlarmTags are tags that the CloudWatch alarm must have.
+	AlarmTags map[string]string `yaml:"alarm_tags"`
+	// LogLevels match the level field of any of the structured logs.
+	LogLevels []string `yaml:"log_levels"`
+}
+
+type SeverityLevel struct {
+	// Slack is merged over the usual settings, e.g. to change attachment_color.
+	Slack SlackConfig `yaml:"slack"`
+	// Mentions are put before the message, e.g. "<!channel>".
+	Mentions []string `yaml:"mentions"`
+	// Delivery is "immediate" (default), or "digest" to only report the
+	// notifications in digests.
+	Delivery string `yaml:"delivery"`
+	// Sinks are "slack" and "pagerduty", only slack by default.
+	Sinks     []string        `yaml:"sinks"`
+	PagerDuty PagerDutyConfig `yaml:"pagerduty"`
+}
+
+var defaultSeverityColors = map[string]string{
+	SeverityCritical: "danger",
+	SeverityError:    "danger",
+	SeverityWarning:  "warning",
+	SeverityInfo:     "good",
+}
+
+func (c SeverityConfig) GetDefault() string {
+	if c.Default == "" {
+		return SeverityError
+	}
+	return c.Default
+}
+
+// UsesAlarmTags reports whether any rule needs the tags of the alarm.
+func (c SeverityConfig) UsesAlarmTags() bool {
+	for _, r := range c.Rules {
+		if len(r.AlarmTags) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Of returns the severity of a notification of the alarm with logs of levels.
+func (c SeverityConfig) Of(alarmName string, tags map[string]string, levels []string) string {
+	for _, r := range c.Rules {
+		if r.matches(alarmName, tags, levels) {
+			return r.Severity
+		}
+	}
+	return c.GetDefault()
+}
+
+func (r SeverityRule) matches(alarmName string, tags map[string]string, levels []string) bool {
+	if len(r.AlarmNames) > 0 {
+		var ok bool
+		for _, p := range r.AlarmNames {
+			if glob.MustCompile(p).Match(alarmName) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	for k, v := range r.AlarmTags {
+		if tv, ok := tags[k]; !ok || tv != v {
+			return false
+		}
+	}
+	if len(r.LogLevels) > 0 {
+		var ok bool
+		for _, want := range r.LogLevels {
+			for _, l := range levels {
+				if strings.EqualFold(want, l) {
+					ok = true
+				}
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// SeverityColor is the attachment color of severity when none is configured.
+func SeverityColor(severity string) string {
+	return defaultSeverityColors[severity]
+}
+
+// Level returns the delivery settings of severity, with defaults applied.
+func (c SeverityConfig) Level(severity string) SeverityLevel {
+	l := c.Levels[severity]
+	if l.Delivery == "" {
+		l.Delivery = DeliveryImmediate
+	}
+	if l.Sinks == nil {
+		l.Sinks = []string{SinkSlack}
+	}
+	return l
+}
+
+// PagerDutyConfig sends events to PagerDuty with the Events API v2.
+type PagerDutyConfig struct {
+	RoutingKey string `yaml:"routing_key"`
+	// URL overrides the Events API endpoint.
+	URL string `yaml:"url"`
+}
+
+func (c *PagerDutyConfig) Merge(pc PagerDutyConfig) {
+	if pc.RoutingKey != "" {
+		c.RoutingKey = pc.RoutingKey
+	}
+	if pc.URL != "" {
+		c.URL = pc.URL
+	}
+}
+
+func (c PagerDutyConfig) GetURL() string {
+	if c.URL == "" {
+		return "https://events.pagerduty.com/v2/enqueue"
+	}
+	return c.URL
+}
+
+// EffectivePagerDuty returns the PagerDuty settings of severity.
+func (c *Config) EffectivePagerDuty(severity string) PagerDutyConfig {
+	pd := c.PagerDuty
+	pd.Merge(c.Severity.Levels[severity].PagerDuty)
+	return pd
+}
@@ -0,0 +1,427 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"github.com/yuichiro-h/cwl-alert-notifier/multiline"
+	"github.com/yuichiro-h/cwl-alert-notifier/redact"
+	"github.com/yuichiro-h/cwl-alert-notifier/window"
+)
+
+// ValidationError holds every problem found in a config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid config:\n  - %s", strings.Join(e.Problems, "\n  - "))
+}
+
+func (e *ValidationError) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// Validate checks the semantic consistency of the config and reports all problems at once.
+func (c *Config) Validate() error {
+	var verr ValidationError
+
+	if c.AWS.Region == "" {
+		verr.add("aws.region is required")
+	}
+	if b := c.Log.RangeDuration.Before; b != nil && *b < 0 {
+		verr.add("log.range_duration.before must not be negative")
+	}
+	if a := c.Log.RangeDuration.After; a != nil && *a < 0 {
+		verr.add("log.range_duration.after must not be negative")
+	}
+	if n := c.Log.MaxEvents; n != nil && *n < 1 {
+		verr.add("log.max_events must be at least 1")
+	}
+	if n := c.Log.MaxDisplayedEvents; n != nil && *n < 1 {
+		verr.add("log.max_displayed_events must be at least 1")
+	}
+
+	if w := c.Reload.WatchInterval; w != nil && *w < 0 {
+		verr.add("reload.watch_interval must not be negative")
+	}
+
+	if t := c.Shutdown.Timeout; t != nil && *t < 0 {
+		verr.add("shutdown.timeout must not be negative")
+	}
+
+	if c.HTTP.Listen != "" && c.HTTP.ApiToken == "" {
+		verr.add("http.api_token is required when http.listen is set")
+	}
+
+	if c.Retry.GetMaxReceives() < 1 {
+		verr.add("retry.max_receives must be at least 1")
+	}
+	if c.Retry.OpsSlack.Channel != "" {
+		ops := c.Slack
+		ops.Merge(c.Retry.OpsSlack)
+		validateSlack(&verr, "retry.ops_slack", ops)
+	}
+
+	switch c.State.Type {
+	case "", "memory":
+	case "file":
+		if c.State.Path == "" {
+			verr.add("state.path is required for file state")
+		}
+	case "dynamodb":
+		if c.State.DynamoDB.Table == "" {
+			verr.add("state.dynamodb.table is required for dynamodb state")
+		}
+	default:
+		verr.add("state.type must be one of memory, file or dynamodb: %q", c.State.Type)
+	}
+	if d := c.History.RetentionDays; d != nil && *d < 1 {
+		verr.add("history.retention_days must be at least 1")
+	}
+
+	if p := c.RateLimit.PerMinute; p != nil && *p <= 0 {
+		verr.add("rate_limit.per_minute must be positive")
+	}
+	if c.RateLimit.GetBurst() < 1 {
+		verr.add("rate_limit.burst must be at least 1")
+	}
+	if c.RateLimit.GetInterval() < 60 {
+		verr.add("rate_limit.interval must be at least 60")
+	}
+
+	for i, d := range c.Digests {
+		if _, err := window.ParseSchedule(d.Schedule, d.Timezone); err != nil {
+			verr.add("digests[%d]: %s", i, err)
+		}
+		if p := d.GetPeriod(); p <= 0 || p > 7*24*60*60 {
+			verr.add("digests[%d].period must be between 1 and 604800", i)
+		}
+		if d.GetTop() < 0 {
+			verr.add("digests[%d].top must not be negative", i)
+		}
+	}
+
+	builtin := redact.Builtin()
+	for i, name := range c.Redaction.Detectors {
+		if _, ok := builtin[name]; !ok {
+			verr.add("redaction.detectors[%d]: unknown detector %q", i, name)
+		}
+	}
+	for i, p := range c.Redaction.Patterns {
+		if p.Name == "" {
+			verr.add("redaction.patterns[%d].name is required", i)
+		}
+		if _, err := regexp.Compile(p.Regex); err != nil {
+			verr.add("redaction.patterns[%d]: invalid regex %q: %s", i, p.Regex, err)
+		}
+	}
+
+	c.validateSeverity(&verr)
+
+	var rotations []string
+	for name := range c.OnCall {
+		rotations = append(rotations, name)
+	}
+	sort.Strings(rotations)
+	for _, name := range rotations {
+		if err := c.OnCall[name].Validate(); err != nil {
+			verr.add("on_call.%s: %s", name, err)
+		}
+	}
+
+	ids := map[string]bool{}
+	for i := range c.Silences.Rules {
+		s := &c.Silences.Rules[i]
+		if s.ID == "" {
+			verr.add("silences.rules[%d].id is required", i)
+		} else if ids[s.ID] {
+			verr.add("silences.rules[%d].id %q is duplicated", i, s.ID)
+		}
+		ids[s.ID] = true
+		if err := s.Validate(); err != nil {
+			verr.add("silences.rules[%d]: %s", i, err)
+		}
+	}
+
+	validateTemplates(&verr, "templates", c.Templates)
+
+	if len(c.Alarms) == 0 {
+		verr.add("alarms must contain at least one alarm")
+	}
+
+	for _, name := range c.AlarmNames() {
+		alarm := c.Alarms[name]
+		path := fmt.Sprintf("alarms.%s", name)
+
+		if alarm.SqsURL == "" {
+			verr.add("%s.sqs_url is required", path)
+		}
+		if n := alarm.Receive.GetConcurrency(); n < 1 {
+			verr.add("%s.receive.concurrency must be at least 1", path)
+		}
+		if n := alarm.Receive.GetMaxMessages(); n < 1 || n > 10 {
+			verr.add("%s.receive.max_messages must be between 1 and 10", path)
+		}
+		if n := alarm.Receive.GetWaitTimeSeconds(); n < 0 || n > 20 {
+			verr.add("%s.receive.wait_time_seconds must be between 0 and 20", path)
+		}
+		if n := alarm.Receive.GetVisibilityTimeout(); n < 0 || n > 43200 {
+			verr.add("%s.receive.visibility_timeout must be between 0 and 43200", path)
+		}
+
+		validateSlack(&verr, path, c.EffectiveSlack(alarm, nil))
+		validateTemplates(&verr, path+".templates", alarm.Templates)
+		c.validateOnCall(&verr, path, alarm.OnCall)
+		validateMultiline(&verr, path+".multiline", alarm.Multiline)
+		validateWindows(&verr, path+".maintenance_windows", alarm.MaintenanceWindows, true)
+		validateWindows(&verr, path+".quiet_hours", alarm.QuietHours, false)
+
+		for i := range alarm.Groups {
+			g := &alarm.Groups[i]
+			gpath := fmt.Sprintf("%s.groups[%d]", path, i)
+
+			if len(g.LogGroups) == 0 && len(g.AWSBatchJobDefinitions) == 0 {
+				verr.add("%s must have at least one of log_groups or awsbatch_job_definitions", gpath)
+			}
+			for j, p := range g.LogGroups {
+				if _, err := glob.Compile(p); err != nil {
+					verr.add("%s.log_groups[%d]: invalid pattern %q: %s", gpath, j, p, err)
+				}
+			}
+			for j, p := range g.AWSBatchJobDefinitions {
+				if _, err := glob.Compile(p); err != nil {
+					verr.add("%s.awsbatch_job_definitions[%d]: invalid pattern %q: %s", gpath, j, p, err)
+				}
+			}
+
+			validateSlack(&verr, gpath, c.EffectiveSlack(alarm, g))
+			validateTemplates(&verr, gpath+".templates", g.Templates)
+			c.validateOnCall(&verr, gpath, g.OnCall)
+			validateMultiline(&verr, gpath+".multiline", g.Multiline)
+			if g.ContextBefore < 0 || g.ContextBefore > maxContextLines {
+				verr.add("%s.context_before must be between 0 and %d", gpath, maxContextLines)
+			}
+			if g.ContextAfter < 0 || g.ContextAfter > maxContextLines {
+				verr.add("%s.context_after must be between 0 and %d", gpath, maxContextLines)
+			}
+			validateWindows(&verr, gpath+".maintenance_windows", g.MaintenanceWindows, true)
+			validateWindows(&verr, gpath+".quiet_hours", g.QuietHours, false)
+		}
+	}
+
+	if len(verr.Problems) > 0 {
+		return &verr
+	}
+	return nil
+}
+
+func (c *Config) validateSeverity(verr *ValidationError) {
+	if !isSeverity(c.Severity.GetDefault()) {
+		verr.add("severity.default must be one of %s: %q", strings.Join(Severities, ", "), c.Severity.Default)
+	}
+	for i, r := range c.Severity.Rules {
+		if !isSeverity(r.Severity) {
+			verr.add("severity.rules[%d].severity must be one of %s: %q", i, strings.Join(Severities, ", "), r.Severity)
+		}
+		for j, p := range r.AlarmNames {
+			if _, err := glob.Compile(p); err != nil {
+				verr.add("severity.rules[%d].alarm_names[%d]: invalid pattern %q: %s", i, j, p, err)
+			}
+		}
+	}
+
+	for _, severity := range Severities {
+		l, ok := c.Severity.Levels[severity]
+		if !ok {
+			continue
+		}
+		path := "severity.levels." + severity
+		switch l.Delivery {
+		case "", DeliveryImmediate, DeliveryDigest:
+		default:
+			verr.add("%s.delivery must be immediate or digest: %q", path, l.Delivery)
+		}
+		if l.Sinks != nil && len(l.Sinks) == 0 {
+			verr.add("%s.sinks must not be empty", path)
+		}
+		for i, sink := range l.Sinks {
+			switch sink {
+			case SinkSlack:
+			case SinkPagerDuty:
+				if c.EffectivePagerDuty(severity).RoutingKey == "" {
+					verr.add("%s.sinks[%d]: pagerduty.routing_key is required", path, i)
+				}
+			default:
+				verr.add("%s.sinks[%d] must be slack or pagerduty: %q", path, i, sink)
+			}
+		}
+		if level := c.Severity.Level(severity); level.Delivery == DeliveryDigest && containsString(level.Sinks, SinkSlack) {
+			c.validateDigestDelivery(verr, path, level)
+		}
+	}
+	var unknown []string
+	for severity := range c.Severity.Levels {
+		if !isSeverity(severity) {
+			unknown = append(unknown, severity)
+		}
+	}
+	sort.Strings(unknown)
+	for _, severity := range unknown {
+		verr.add("severity.levels: unknown severity %q", severity)
+	}
+}
+
+// validateDigestDelivery checks that the notifications left to digests by
+// level are reported by a digest on every channel they can be sent to.
+func (c *Config) validateDigestDelivery(verr *ValidationError, path string, level SeverityLevel) {
+	if len(c.Digests) == 0 {
+		verr.add("%s.delivery is digest but no digests are configured", path)
+		return
+	}
+	for _, channel := range c.levelChannels(level) {
+		var reported bool
+		for _, d := range c.Digests {
+			if len(d.Channels) == 0 || containsString(d.Channels, channel) {
+				reported = true
+				break
+			}
+		}
+		if !reported {
+			verr.add("%s.delivery is digest but no digest reports channel %q", path, channel)
+		}
+	}
+}
+
+// levelChannels returns the channels that notifications of level can be sent
+// to, including routes of maintenance windows and quiet hours.
+func (c *Config) levelChannels(level SeverityLevel) []string {
+	seen := map[string]bool{}
+	var channels []string
+	add := func(slack SlackConfig, windows []Window) {
+		slack.Merge(level.Slack)
+		routes := []SlackConfig{slack}
+		for _, w := range windows {
+			if w.GetAction() == WindowActionRoute {
+				route := slack
+				route.Merge(w.Slack)
+				routes = append(routes, route)
+			}
+		}
+		for _, sc := range routes {
+			if sc.Channel != "" && !seen[sc.Channel] {
+				seen[sc.Channel] = true
+				channels = append(channels, sc.Channel)
+			}
+		}
+	}
+	for _, name := range c.AlarmNames() {
+		alarm := c.Alarms[name]
+		add(c.EffectiveSlack(alarm, nil), alarm.Windows(nil))
+		for i := range alarm.Groups {
+			g := &alarm.Groups[i]
+			add(c.EffectiveSlack(alarm, g), alarm.Windows(g))
+		}
+	}
+	return channels
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isSeverity(s string) bool {
+	for _, v := range Severities {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) validateOnCall(verr *ValidationError, path, name string) {
+	if _, ok := c.OnCall[name]; name != "" && !ok {
+		verr.add("%s.on_call: unknown rotation %q", path, name)
+	}
+}
+
+func validateTemplates(verr *ValidationError, path string, templates TemplateConfig) {
+	if _, err := templates.Compile(); err != nil {
+		verr.add("%s: %s", path, err)
+	}
+}
+
+// maxContextLines keeps the lines fetched around an event within one page of
+// GetLogEvents.
+const maxContextLines = 1000
+
+func validateMultiline(verr *ValidationError, path string, c MultilineConfig) {
+	if c.Preset != "" {
+		if _, ok := multiline.Presets()[c.Preset]; !ok {
+			verr.add("%s.preset: unknown preset %q", path, c.Preset)
+		}
+	}
+	if c.Start != "" && !c.Enabled() {
+		verr.add("%s.start requires preset or continuation", path)
+	}
+	if _, err := regexp.Compile(c.Start); err != nil {
+		verr.add("%s.start: %s", path, err)
+	}
+	if _, err := regexp.Compile(c.Continuation); err != nil {
+		verr.add("%s.continuation: %s", path, err)
+	}
+	if n := c.GetMaxLines(); n < 1 || n > maxContextLines {
+		verr.add("%s.max_lines must be between 1 and %d", path, maxContextLines)
+	}
+}
+
+func validateSlack(verr *ValidationError, path string, slack SlackConfig) {
+	if slack.ApiToken == "" {
+		verr.add("%s: effective slack.api_token is empty", path)
+	}
+	if slack.Channel == "" {
+		verr.add("%s: effective slack.channel is empty", path)
+	}
+}
+
+func validateWindows(verr *ValidationError, path string, windows []Window, scheduled bool) {
+	for i, w := range windows {
+		wpath := fmt.Sprintf("%s[%d]", path, i)
+		if scheduled && w.Schedule == "" {
+			verr.add("%s.schedule is required", wpath)
+		}
+		if !scheduled && w.Schedule != "" {
+			verr.add("%s.schedule is not allowed in quiet hours", wpath)
+		}
+		if err := w.Validate(); err != nil {
+			verr.add("%s: %s", wpath, err)
+		}
+		switch w.GetAction() {
+		case WindowActionSuppress, WindowActionDigest:
+		case WindowActionRoute:
+			if w.Slack.Channel == "" {
+				verr.add("%s.slack.channel is required for route", wpath)
+			}
+		default:
+			verr.add("%s.action must be one of suppress, digest or route: %q", wpath, w.Action)
+		}
+	}
+}
+
+// AlarmNames returns the configured alarm names in a stable order.
+func (c *Config) AlarmNames() []AlarmName {
+	names := make([]AlarmName, 0, len(c.Alarms))
+	for name := range c.Alarms {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
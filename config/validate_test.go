@@ -0,0 +1,183 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yuichiro-h/cwl-alert-notifier/window"
+)
+
+func parseString(t *testing.T, data string) (*Config, error) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return Parse(path)
+}
+
+func TestParseReportsAllProblems(t *testing.T) {
+	_, err := parseString(t, `aws:
+  region: ap-northeast-1
+alarms:
+  a:
+    groups:
+      - log_group: /aws/lambda/app
+  b:
+    sqs_url: https://sqs.ap-northeast-1.amazonaws.com/123456789012/b
+`)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Parse() error = %#v, want *ValidationError", err)
+	}
+
+	want := []string{
+		"line 6: field log_group not found in type config.AlarmGroup",
+		"alarms.a.sqs_url is required",
+		"alarms.a: effective slack.api_token is empty",
+		"alarms.a: effective slack.channel is empty",
+		"alarms.a.groups[0] must have at least one of log_groups or awsbatch_job_definitions",
+		"alarms.a.groups[0]: effective slack.api_token is empty",
+		"alarms.a.groups[0]: effective slack.channel is empty",
+		"alarms.b: effective slack.api_token is empty",
+		"alarms.b: effective slack.channel is empty",
+	}
+	if !reflect.DeepEqual(verr.Problems, want) {
+		t.Errorf("problems =\n%s\nwant\n%s", strings.Join(verr.Problems, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseValid(t *testing.T) {
+	conf, err := parseString(t, `aws:
+  region: ap-northeast-1
+slack:
+  api_token: xoxb-token
+  channel: "#alerts"
+alarms:
+  a:
+    sqs_url: https://sqs.ap-northeast-1.amazonaws.com/123456789012/a
+    groups:
+      - log_groups: [/aws/lambda/app-*]
+        slack:
+          channel: "#app"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &conf.Alarms["a"].Groups[0]
+	if got := conf.EffectiveSlack(conf.Alarms["a"], g).Channel; got != "#app" {
+		t.Errorf("group channel = %q, want #app", got)
+	}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	_, err := parseString(t, "alarms: [\n")
+	if err == nil {
+		t.Fatal("Parse() succeeded")
+	}
+	if _, ok := err.(*ValidationError); ok {
+		t.Errorf("Parse() error = %v, want a syntax error", err)
+	}
+}
+
+// validConfig returns a config that passes validation, with alarm a notifying
+// #a, group 0 of it #g and route windows of group 0 #route.
+func validConfig() *Config {
+	c := &Config{}
+	c.AWS.Region = "ap-northeast-1"
+	c.Slack = SlackConfig{ApiToken: "xoxb-token", Channel: "#a"}
+	c.Alarms = map[AlarmName]Alarm{
+		"a": {
+			SqsURL: "https://sqs/a",
+			Groups: []AlarmGroup{{
+				LogGroups: []string{"/app"},
+				Slack:     SlackConfig{Channel: "#g"},
+				QuietHours: []Window{{
+					Window: window.Window{Start: "22:00", End: "06:00", Timezone: "UTC"},
+					Action: WindowActionRoute, Slack: SlackConfig{Channel: "#route"},
+				}},
+			}},
+		},
+	}
+	return c
+}
+
+func TestValidateSeverityLevels(t *testing.T) {
+	digest := func(channels ...string) DigestConfig {
+		return DigestConfig{Schedule: "0 9 * * *", Timezone: "UTC", Channels: channels}
+	}
+
+	tests := []struct {
+		name    string
+		level   SeverityLevel
+		digests []DigestConfig
+		want    []string
+	}{
+		{name: "immediate without digests", level: SeverityLevel{}},
+		{name: "digest to all channels", level: SeverityLevel{Delivery: DeliveryDigest}, digests: []DigestConfig{digest()}},
+		{
+			name:    "digest to every channel",
+			level:   SeverityLevel{Delivery: DeliveryDigest},
+			digests: []DigestConfig{digest("#a", "#route"), digest("#g")},
+		},
+		{
+			name:  "digest without digests",
+			level: SeverityLevel{Delivery: DeliveryDigest},
+			want:  []string{"severity.levels.critical.delivery is digest but no digests are configured"},
+		},
+		{
+			name:    "digest missing channels",
+			level:   SeverityLevel{Delivery: DeliveryDigest},
+			digests: []DigestConfig{digest("#a")},
+			want: []string{
+				`severity.levels.critical.delivery is digest but no digest reports channel "#g"`,
+				`severity.levels.critical.delivery is digest but no digest reports channel "#route"`,
+			},
+		},
+		{
+			name:    "digest to the channel of the level",
+			level:   SeverityLevel{Delivery: DeliveryDigest, Slack: SlackConfig{Channel: "#critical"}},
+			digests: []DigestConfig{digest("#a")},
+			want: []string{
+				`severity.levels.critical.delivery is digest but no digest reports channel "#critical"`,
+				`severity.levels.critical.delivery is digest but no digest reports channel "#route"`,
+			},
+		},
+		{
+			name:  "digest without the slack sink",
+			level: SeverityLevel{Delivery: DeliveryDigest, Sinks: []string{SinkPagerDuty}},
+		},
+		{
+			name:  "empty sinks",
+			level: SeverityLevel{Sinks: []string{}},
+			want:  []string{"severity.levels.critical.sinks must not be empty"},
+		},
+	}
+	for _, tt := range tests {
+		c := validConfig()
+		c.PagerDuty.RoutingKey = "key"
+		c.Digests = tt.digests
+		c.Severity.Levels = map[string]SeverityLevel{SeverityCritical: tt.level}
+
+		var got []string
+		if err := c.Validate(); err != nil {
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("%s: Validate() = %v", tt.name, err)
+			}
+			got = verr.Problems
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: problems =\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
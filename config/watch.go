@@ -0,0 +1,40 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"time"
+)
+
+// Watch calls changed whenever the content of filename changes, checking every
+// interval until stop is closed.
+func Watch(filename string, interval time.Duration, stop <-chan struct{}, changed func()) {
+	last := checksum(filename)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sum := checksum(filename)
+			if sum == nil || bytes.Equal(sum, last) {
+				continue
+			}
+			last = sum
+			changed()
+		}
+	}
+}
+
+func checksum(filename string) []byte {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/log"
+	"github.com/yuichiro-h/cwl-alert-notifier/state"
+	"go.uber.org/zap"
+)
+
+// deferredRetention is how long a deferred notification is kept after it was
+// due, in case it cannot be posted.
+const deferredRetention = 7 * 24 * time.Hour
+
+const (
+	// deferredByWindow is deferred until its maintenance window or quiet hours end.
+	deferredByWindow = "window"
+	// deferredByRateLimit exceeded the rate limit of its destination.
+	deferredByRateLimit = "rate_limit"
+)
+
+// deferredNotification is a notification posted later as part of one digest
+// message per channel. The Slack api token is not stored; it is looked up from
+// the current config on flush.
+type deferredNotification struct {
+	Reason          string             `json:"reason"`
+	Alarm           config.AlarmName   `json:"alarm"`
+	GroupIndex      int                `json:"group_index"`
+	Slack           config.SlackConfig `json:"slack"`
+	ApplicationName string             `json:"application_name"`
+	FirstLogURL     string             `json:"first_log_url"`
+	EventCount      int                `json:"event_count"`
+	FlushAt         time.Time          `json:"flush_at"`
+}
+
+func deferredKey(flushAt time.Time, id string) string {
+	return fmt.Sprintf("deferred/%d/%s", flushAt.Unix(), id)
+}
+
+// addDeferred stores in to be posted at flushAt.
+func addDeferred(ctx context.Context, store state.Store, id, reason string, flushAt time.Time, in *notifyInput) error {
+	d := deferredNotification{
+		Reason:          reason,
+		Alarm:           in.AlarmName,
+		GroupIndex:      in.GroupIndex,
+		Slack:           in.Slack,
+		ApplicationName: in.ApplicationName,
+		FirstLogURL:     in.FirstLogURL,
+		EventCount:      len(in.Events),
+		FlushAt:         flushAt,
+	}
+	d.Slack.ApiToken = ""
+
+	data, err := json.Marshal(&d)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	ttl := d.FlushAt.Add(deferredRetention).Sub(time.Now())
+	return errors.WithStack(store.Put(ctx, deferredKey(d.FlushAt, id), data, ttl))
+}
+
+// flushDeferred posts one digest message per channel and reason for the
+// deferred notifications that are due at now.
+func flushDeferred(ctx context.Context, store state.Store, conf *config.Config, now time.Time) error {
+	items, err := store.List(ctx, "deferred/")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	type batch struct {
+		slack   config.SlackConfig
+		reason  string
+		keys    []string
+		digests []deferredNotification
+	}
+	batches := map[string]*batch{}
+	var order []string
+	for _, item := range items {
+		// キーは deferred/{flushAt}/{id}
+		parts := strings.SplitN(item.Key, "/", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		flushAt, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || time.Unix(flushAt, 0).After(now) {
+			continue
+		}
+
+		var d deferredNotification
+		if err := json.Unmarshal(item.Value, &d); err != nil {
+			log.Get().Error("broken deferred notification", zap.String("key", item.Key), zap.Error(err))
+			continue
+		}
+		d.Slack.ApiToken = slackToken(conf, d.Alarm, d.GroupIndex)
+
+		k := strings.Join([]string{parts[1], d.Reason, d.Slack.ApiToken, d.Slack.Channel}, "\x00")
+		b, ok := batches[k]
+		if !ok {
+			b = &batch{slack: d.Slack, reason: d.Reason}
+			batches[k] = b
+			order = append(order, k)
+		}
+		b.keys = append(b.keys, item.Key)
+		b.digests = append(b.digests, d)
+	}
+
+	for _, k := range order {
+		b := batches[k]
+		if err := postDeferred(ctx, b.slack, b.reason, b.digests, conf); err != nil {
+			return err
+		}
+		for _, key := range b.keys {
+			if err := store.Delete(ctx, key); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+	return nil
+}
+
+func postDeferred(ctx context.Context, sc config.SlackConfig, reason string, digests []deferredNotification, conf *config.Config) error {
+	sort.Slice(digests, func(i, j int) bool { return digests[i].ApplicationName < digests[j].ApplicationName })
+
+	var total int
+	body := strings.Builder{}
+	for _, d := range digests {
+		total += d.EventCount
+		body.WriteString(fmt.Sprintf("• <%s|%s>: %d event(s)\n", d.FirstLogURL, d.ApplicationName, d.EventCount))
+	}
+
+	params := slack.PostMessageParameters{
+		Markdown: true,
+		Username: sc.Username,
+		IconURL:  sc.IconURL,
+		Attachments: []slack.Attachment{
+			{
+				Color:      sc.AttachmentColor,
+				MarkdownIn: []string{"text"},
+				Text:       body.String(),
+			},
+		},
+	}
+
+	text := fmt.Sprintf("%d log event(s) were found during the quiet window", total)
+	if reason == deferredByRateLimit {
+		text = fmt.Sprintf("%d alert(s) in the last %d minute(s)", len(digests), conf.RateLimit.GetInterval()/60)
+	}
+	return postMessage(ctx, sc, text, params)
+}
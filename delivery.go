@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yuichiro-h/cwl-alert-notifier/state"
+)
+
+// deliveryTTL is how long a delivery is remembered. It covers the maximum
+// message retention period of SQS.
+const deliveryTTL = 14 * 24 * time.Hour
+
+// deliveries records notifications that have gone out, so that a redelivered
+// message only sends what has not been sent yet.
+type deliveries struct {
+	store state.Store
+}
+
+// notificationID identifies the notification of appName for an SNS message on sink.
+func notificationID(messageID, appName, sink string) string {
+	sum := sha256.Sum256([]byte(messageID + "\x00" + appName + "\x00" + sink))
+	return hex.EncodeToString(sum[:])
+}
+
+func (d *deliveries) key(id string) string {
+	return "delivery/" + id
+}
+
+func (d *deliveries) Delivered(ctx context.Context, id string) (bool, error) {
+	_, err := d.store.Get(ctx, d.key(id))
+	if err == state.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return true, nil
+}
+
+func (d *deliveries) MarkDelivered(ctx context.Context, id string) error {
+	value := []byte(time.Now().UTC().Format(time.RFC3339))
+	if err := d.store.Put(ctx, d.key(id), value, deliveryTTL); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
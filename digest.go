@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/log"
+	"github.com/yuichiro-h/cwl-alert-notifier/state"
+	"github.com/yuichiro-h/cwl-alert-notifier/window"
+	"go.uber.org/zap"
+)
+
+// alertStatsRetention covers the longest digest period supported without
+// losing data, a week, plus a day of slack.
+const alertStatsRetention = 8 * 24 * time.Hour
+
+// digestSampleLength is how much of a log message is kept as a sample.
+const digestSampleLength = 200
+
+// alertStat counts the notifications of an application on a channel per hour.
+// Stats with a Fingerprint count the events of one error fingerprint.
+type alertStat struct {
+	// Hour is the start of the hour counted.
+	Hour            time.Time        `json:"hour"`
+	Alarm           config.AlarmName `json:"alarm"`
+	GroupIndex      int              `json:"group_index"`
+	Channel         string           `json:"channel"`
+	ApplicationName string           `json:"application_name"`
+	Fingerprint     string           `json:"fingerprint,omitempty"`
+	Sample          string           `json:"sample,omitempty"`
+	Notifications   int              `json:"notifications"`
+	Events          int              `json:"events"`
+	FirstSeen       time.Time        `json:"first_seen"`
+	LastSeen        time.Time        `json:"last_seen"`
+	LastLogURL      string           `json:"last_log_url"`
+}
+
+// alertStats aggregates notifications for digests.
+type alertStats struct {
+	store state.Store
+	// mu serializes read-modify-write of stats within the process.
+	mu sync.Mutex
+}
+
+func alertStatKey(hour time.Time, channel, app, fp string) string {
+	sum := sha1.Sum([]byte(channel + "\x00" + app + "\x00" + fp))
+	return fmt.Sprintf("alertstats/%s/%s", hour.UTC().Format("2006-01-02T15"), hex.EncodeToString(sum[:]))
+}
+
+// Record counts in as one notification of its application.
+func (s *alertStats) Record(ctx context.Context, in *notifyInput, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	base := alertStat{
+		Alarm:           in.AlarmName,
+		GroupIndex:      in.GroupIndex,
+		Channel:         in.Slack.Channel,
+		ApplicationName: in.ApplicationName,
+		LastLogURL:      in.FirstLogURL,
+	}
+
+	app := base
+	app.Notifications = 1
+	app.Events = len(in.Events)
+	if err := s.add(ctx, now, app); err != nil {
+		return err
+	}
+
+	byFingerprint := map[string]*alertStat{}
+	var fps []string
+	for _, e := range in.Events {
+		fp := e.Fingerprint()
+		st, ok := byFingerprint[fp]
+		if !ok {
+			st = &alertStat{}
+			*st = base
+			st.Fingerprint = fp
+			st.Sample = truncate(e.Message, digestSampleLength)
+			st.Notifications = 1
+			byFingerprint[fp] = st
+			fps = append(fps, fp)
+		}
+		st.Events++
+	}
+	for _, fp := range fps {
+		if err := s.add(ctx, now, *byFingerprint[fp]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *alertStats) add(ctx context.Context, now time.Time, delta alertStat) error {
+	key := alertStatKey(now, delta.Channel, delta.ApplicationName, delta.Fingerprint)
+
+	st := delta
+	st.Hour = now.UTC().Truncate(time.Hour)
+	st.FirstSeen = now
+	data, err := s.store.Get(ctx, key)
+	if err != nil && err != state.ErrNotFound {
+		return errors.WithStack(err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &st); err != nil {
+			return errors.WithStack(err)
+		}
+		st.Notifications += delta.Notifications
+		st.Events += delta.Events
+		st.LastLogURL = delta.LastLogURL
+	}
+	st.LastSeen = now
+
+	data, err = json.Marshal(&st)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(s.store.Put(ctx, key, data, alertStatsRetention))
+}
+
+// Between returns the stats of the hours starting from from and before to,
+// summed per key over the hours.
+func (s *alertStats) Between(ctx context.Context, from, to time.Time) ([]alertStat, error) {
+	items, err := s.list(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	summed := map[string]*alertStat{}
+	var keys []string
+	for _, item := range items {
+		var st alertStat
+		if err := json.Unmarshal(item.Value, &st); err != nil {
+			log.Get().Error("broken alert stat", zap.String("key", item.Key), zap.Error(err))
+			continue
+		}
+		if st.Hour.Before(from) || !st.Hour.Before(to) {
+			continue
+		}
+
+		k := st.Channel + "\x00" + st.ApplicationName + "\x00" + st.Fingerprint
+		sum, ok := summed[k]
+		if !ok {
+			summed[k] = &st
+			keys = append(keys, k)
+			continue
+		}
+		sum.Notifications += st.Notifications
+		sum.Events += st.Events
+		if st.FirstSeen.Before(sum.FirstSeen) {
+			sum.FirstSeen = st.FirstSeen
+		}
+		if st.LastSeen.After(sum.LastSeen) {
+			sum.LastSeen = st.LastSeen
+			sum.LastLogURL = st.LastLogURL
+		}
+	}
+
+	stats := make([]alertStat, 0, len(keys))
+	for _, k := range keys {
+		stats = append(stats, *summed[k])
+	}
+	return stats, nil
+}
+
+// list returns the stats of the hours from from to to, listing whole UTC days
+// at once and the hours at either end one by one.
+func (s *alertStats) list(ctx context.Context, from, to time.Time) ([]state.Item, error) {
+	var items []state.Item
+	h := from.UTC().Truncate(time.Hour)
+	for h.Before(to) {
+		prefix, next := h.Format("2006-01-02T15"), h.Add(time.Hour)
+		if day := h.Add(24 * time.Hour); h.Hour() == 0 && !day.After(to) {
+			prefix, next = h.Format("2006-01-02"), day
+		}
+		listed, err := s.store.List(ctx, "alertstats/"+prefix)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		items = append(items, listed...)
+		h = next
+	}
+	return items, nil
+}
+
+// digestScheduler posts the configured digests when their schedules fire.
+type digestScheduler struct {
+	svc *services
+	// lastRun is keyed by digestKey so that it survives reloads that reorder
+	// the digests.
+	lastRun map[string]time.Time
+}
+
+func newDigestScheduler(svc *services) *digestScheduler {
+	return &digestScheduler{
+		svc:     svc,
+		lastRun: map[string]time.Time{},
+	}
+}
+
+// digestKey identifies dc by its schedule and channels.
+func digestKey(dc config.DigestConfig) string {
+	channels := append([]string(nil), dc.Channels...)
+	sort.Strings(channels)
+	return strings.Join([]string{dc.Schedule, dc.Timezone, strings.Join(channels, ",")}, "\x00")
+}
+
+// Run posts every digest whose schedule has fired since it was last checked.
+func (d *digestScheduler) Run(ctx context.Context, conf *config.Config) error {
+	now := time.Now()
+	configured := map[string]bool{}
+	for _, dc := range conf.Digests {
+		key := digestKey(dc)
+		// 同じスケジュールの digest が複数あっても別々に記録する
+		for n := 2; configured[key]; n++ {
+			key = fmt.Sprintf("%s\x00%d", digestKey(dc), n)
+		}
+		configured[key] = true
+
+		last, ok := d.lastRun[key]
+		d.lastRun[key] = now
+		if !ok {
+			// 起動前に予定されていた回は送らない
+			continue
+		}
+
+		sched, err := window.ParseSchedule(dc.Schedule, dc.Timezone)
+		if err != nil {
+			return err
+		}
+		loc, _ := time.LoadLocation(dc.Timezone)
+		if sched.Next(last.In(loc)).After(now) {
+			continue
+		}
+
+		if err := d.post(ctx, conf, dc, now); err != nil {
+			return err
+		}
+	}
+
+	for key := range d.lastRun {
+		if !configured[key] {
+			delete(d.lastRun, key)
+		}
+	}
+	return nil
+}
+
+func (d *digestScheduler) post(ctx context.Context, conf *config.Config, dc config.DigestConfig, now time.Time) error {
+	period := time.Duration(dc.GetPeriod()) * time.Second
+	// 集計は時間単位なので、前回の digest と重ならないよう正時で区切る
+	to := now.Truncate(time.Hour)
+	stats, err := d.svc.stats.Between(ctx, to.Add(-period), to)
+	if err != nil {
+		return err
+	}
+
+	byChannel := map[string][]alertStat{}
+	var channels []string
+	for _, st := range stats {
+		if len(dc.Channels) > 0 && !containsString(dc.Channels, st.Channel) {
+			continue
+		}
+		if _, ok := byChannel[st.Channel]; !ok {
+			channels = append(channels, st.Channel)
+		}
+		byChannel[st.Channel] = append(byChannel[st.Channel], st)
+	}
+	sort.Strings(channels)
+
+	for _, channel := range channels {
+		stats := byChannel[channel]
+		sc := conf.Slack
+		sc.Merge(dc.Slack)
+		sc.Channel = channel
+		if dc.Slack.ApiToken == "" {
+			sc.ApiToken = slackToken(conf, stats[0].Alarm, stats[0].GroupIndex)
+		}
+
+		text, params := digestMessage(sc, stats, period, dc.GetTop())
+		if err := postMessage(ctx, sc, text, params); err != nil {
+			return err
+		}
+		log.Get().Info("posted digest", zap.String("channel", channel), zap.Int("stats", len(stats)))
+	}
+	return nil
+}
+
+func digestMessage(sc config.SlackConfig, stats []alertStat, period time.Duration, top int) (string, slack.PostMessageParameters) {
+	var apps, errs []alertStat
+	for _, st := range stats {
+		if st.Fingerprint == "" {
+			apps = append(apps, st)
+		} else {
+			errs = append(errs, st)
+		}
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].Notifications > apps[j].Notifications })
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Events > errs[j].Events })
+	if len(errs) > top {
+		errs = errs[:top]
+	}
+
+	var total int
+	appText := strings.Builder{}
+	for _, st := range apps {
+		total += st.Notifications
+		appText.WriteString(fmt.Sprintf("• <%s|%s>: %d alarm(s), %d event(s)\n",
+			st.LastLogURL, st.ApplicationName, st.Notifications, st.Events))
+	}
+
+	errText := strings.Builder{}
+	for _, st := range errs {
+		errText.WriteString(fmt.Sprintf("• *%d×* %s `%s` (first %s, last %s, <%s|log>)\n```%s```\n",
+			st.Events, st.ApplicationName, st.Fingerprint,
+			st.FirstSeen.In(time.Local).Format("01/02 15:04"),
+			st.LastSeen.In(time.Local).Format("01/02 15:04"),
+			st.LastLogURL, st.Sample))
+	}
+
+	params := slack.PostMessageParameters{
+		Markdown: true,
+		Username: sc.Username,
+		IconURL:  sc.IconURL,
+		Attachments: []slack.Attachment{
+			{
+				Color:      sc.AttachmentColor,
+				Title:      "Alarms per application",
+				MarkdownIn: []string{"text"},
+				Text:       appText.String(),
+			},
+			{
+				Color:      sc.AttachmentColor,
+				Title:      "Top errors",
+				MarkdownIn: []string{"text"},
+				Text:       errText.String(),
+			},
+		},
+	}
+
+	text := fmt.Sprintf("*Alert digest*: %d alarm(s) in the last %s", total, formatPeriod(period))
+	return text, params
+}
+
+func formatPeriod(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%d day(s)", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/state"
+)
+
+func TestAlertStatsBetween(t *testing.T) {
+	ctx := context.Background()
+	stats := &alertStats{store: state.NewMemoryStore()}
+
+	base := time.Date(2026, 10, 17, 22, 30, 0, 0, time.UTC)
+	for h := 0; h < 30; h++ {
+		st := alertStat{Channel: "#a", ApplicationName: "app", Notifications: 1, Events: 2}
+		if err := stats.add(ctx, base.Add(time.Duration(h)*time.Hour), st); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		from, to time.Time
+		want     int
+	}{
+		{base.Truncate(time.Hour), base.Add(30 * time.Hour), 30},
+		// 前日の最後の 2 時間、翌日の 24 時間、翌々日の最初の 1 時間
+		{time.Date(2026, 10, 17, 22, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 1, 0, 0, 0, time.UTC), 27},
+		{time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), 24},
+		{time.Date(2026, 10, 18, 5, 0, 0, 0, time.UTC), time.Date(2026, 10, 18, 7, 0, 0, 0, time.UTC), 2},
+		{time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 21, 0, 0, 0, 0, time.UTC), 0},
+	}
+	for _, tt := range tests {
+		got, err := stats.Between(ctx, tt.from, tt.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		for _, st := range got {
+			n += st.Notifications
+		}
+		if n != tt.want {
+			t.Errorf("Between(%s, %s) counted %d notifications, want %d", tt.from, tt.to, n, tt.want)
+		}
+	}
+}
+
+func TestDigestSchedulerKeepsLastRunAcrossReorder(t *testing.T) {
+	daily := config.DigestConfig{Schedule: "0 9 * * *", Timezone: "UTC"}
+	weekly := config.DigestConfig{Schedule: "0 9 * * 1", Timezone: "UTC", Channels: []string{"#a"}}
+
+	d := newDigestScheduler(nil)
+	if err := d.Run(context.Background(), &config.Config{Digests: []config.DigestConfig{daily, weekly, daily}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.lastRun) != 3 {
+		t.Fatalf("lastRun = %v, want 3 digests", d.lastRun)
+	}
+	for _, dc := range []config.DigestConfig{daily, weekly} {
+		if _, ok := d.lastRun[digestKey(dc)]; !ok {
+			t.Fatalf("lastRun = %v, want %q", d.lastRun, digestKey(dc))
+		}
+	}
+
+	// 並び替えや削除をしても残った digest は同じキーで記録され続ける
+	if err := d.Run(context.Background(), &config.Config{Digests: []config.DigestConfig{weekly, daily}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.lastRun) != 2 {
+		t.Errorf("lastRun = %v, want the removed digest dropped", d.lastRun)
+	}
+	for _, dc := range []config.DigestConfig{daily, weekly} {
+		if _, ok := d.lastRun[digestKey(dc)]; !ok {
+			t.Errorf("lastRun = %v, want %q", d.lastRun, digestKey(dc))
+		}
+	}
+}
+
+func TestDigestPostToken(t *testing.T) {
+	api, restore := newFakeSlack(t)
+	defer restore()
+
+	ctx := context.Background()
+	svc := newTestServices()
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	for _, ch := range []string{"#a", "#b"} {
+		st := alertStat{Alarm: "app", GroupIndex: 0, Channel: ch, ApplicationName: "app", Notifications: 1, Events: 1}
+		if err := svc.stats.add(ctx, now.Add(-time.Hour), st); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := &config.Config{
+		Slack: config.SlackConfig{ApiToken: "xoxb-global"},
+		Alarms: map[config.AlarmName]config.Alarm{
+			"app": {Groups: []config.AlarmGroup{{Slack: config.SlackConfig{ApiToken: "xoxb-alarm"}}}},
+		},
+	}
+
+	tests := []struct {
+		dc   config.DigestConfig
+		want string
+	}{
+		{config.DigestConfig{Channels: []string{"#a"}, Slack: config.SlackConfig{ApiToken: "xoxb-digest"}}, "xoxb-digest"},
+		{config.DigestConfig{Channels: []string{"#b"}}, "xoxb-alarm"},
+	}
+	d := newDigestScheduler(svc)
+	for _, tt := range tests {
+		n := len(api.Posts())
+		if err := d.post(ctx, conf, tt.dc, now); err != nil {
+			t.Fatal(err)
+		}
+		posts := api.Posts()[n:]
+		if len(posts) != 1 {
+			t.Fatalf("digest of %v posted %d messages, want 1", tt.dc.Channels, len(posts))
+		}
+		if got := posts[0].Get("token"); got != tt.want {
+			t.Errorf("digest of %v posted with %q, want %q", tt.dc.Channels, got, tt.want)
+		}
+		if got := posts[0].Get("channel"); got != tt.dc.Channels[0] {
+			t.Errorf("digest of %v posted to %q", tt.dc.Channels, got)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+// permanentError marks an error that does not go away by retrying, such as a
+// malformed message.
+type permanentError struct {
+	error
+}
+
+func (e *permanentError) Cause() error {
+	return e.error
+}
+
+func permanent(err error) error {
+	return &permanentError{err}
+}
+
+func isPermanent(err error) bool {
+	for err != nil {
+		if _, ok := err.(*permanentError); ok {
+			return true
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = cause.Cause()
+	}
+	return false
+}
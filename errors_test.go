@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestIsPermanent(t *testing.T) {
+	base := errors.New("malformed message")
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"transient", base, false},
+		{"permanent", permanent(base), true},
+		{"with stack", errors.WithStack(permanent(base)), true},
+		{"wrapped", errors.Wrap(permanent(base), "failed to handle"), true},
+		{"permanent wrapping a transient", permanent(errors.Wrap(base, "decode")), true},
+		{"formatted", fmt.Errorf("failed: %v", permanent(base)), false},
+	}
+	for _, tt := range tests {
+		if got := isPermanent(tt.err); got != tt.want {
+			t.Errorf("%s: isPermanent() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package fingerprint
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+var replacements = []struct {
+	pattern *regexp.Regexp
+	repl    string
+}{
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`), "<time>"},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b|\b[0-9a-f]*\d[0-9a-f]*[a-f][0-9a-f]*\b|\b[0-9a-f]*[a-f][0-9a-f]*\d[0-9a-f]*\b`), "<hex>"},
+	{regexp.MustCompile(`\d+`), "<n>"},
+	{regexp.MustCompile(`\s+`), " "},
+}
+
+// Normalize replaces the variable parts of a log message, such as times, ids
+// and numbers, so that occurrences of the same error compare equal.
+func Normalize(msg string) string {
+	for _, r := range replacements {
+		msg = r.pattern.ReplaceAllString(msg, r.repl)
+	}
+	return strings.TrimSpace(msg)
+}
+
+// Of returns a short identifier of the normalized msg.
+func Of(msg string) string {
+	sum := sha1.Sum([]byte(Normalize(msg)))
+	return hex.EncodeToString(sum[:6])
+}
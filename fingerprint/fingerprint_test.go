@@ -0,0 +1,43 @@
+package fingerprint
+
+import (
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"user 42 not found", "user <n> not found"},
+		{"2026-10-18T09:30:00.123Z request failed", "<time> request failed"},
+		{"2026-10-18 09:30:00+09:00 request failed", "<time> request failed"},
+		{"request 3f2b8c1e-9a4d-4e8f-b1c2-7d6e5f4a3b2c timed out", "request <uuid> timed out"},
+		{"connect to 10.0.1.23:5432 refused", "connect to <ip> refused"},
+		{"object 0x7ffde4a8 freed twice", "object <hex> freed twice"},
+		{"commit 9fceb02d0ae598e95dc970b74767f19372d61af8 missing", "commit <hex> missing"},
+		{"  too\n\tmany   spaces  ", "too many spaces"},
+		{"deadline exceeded", "deadline exceeded"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.in); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOf(t *testing.T) {
+	same := []string{
+		"2026-10-18T09:30:00Z order 42 of user 3f2b8c1e-9a4d-4e8f-b1c2-7d6e5f4a3b2c failed from 10.0.1.23",
+		"2026-10-18T11:02:45Z order 1337 of user 0b9e1d2c-3a4f-4b5c-8d6e-7f8091a2b3c4 failed from 10.0.7.5",
+	}
+	if Of(same[0]) != Of(same[1]) {
+		t.Errorf("Of(%q) != Of(%q)", same[0], same[1])
+	}
+	if got := Of(same[0]); len(got) != 12 {
+		t.Errorf("Of() = %q, want 12 hex digits", got)
+	}
+	if Of("order 42 failed") == Of("order 42 shipped") {
+		t.Error("different errors have the same fingerprint")
+	}
+}
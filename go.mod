@@ -10,7 +10,8 @@ require (
 	github.com/mattn/go-colorable v0.1.1 // indirect
 	github.com/nlopes/slack v0.3.0
 	github.com/pkg/errors v0.8.0
-	github.com/yuichiro-h/go v0.0.0-20180903062432-bffc704a83e0
+	github.com/robfig/cron/v3 v3.0.1
+	go.etcd.io/bbolt v1.3.6
 	go.uber.org/atomic v1.3.2 // indirect
 	go.uber.org/multierr v1.1.0 // indirect
 	go.uber.org/zap v1.9.1
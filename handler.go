@@ -1,56 +1,177 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/cenkalti/backoff"
 	"github.com/gobwas/glob"
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
 	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/fingerprint"
 	"github.com/yuichiro-h/cwl-alert-notifier/log"
-	"github.com/yuichiro-h/go/aws/sqsrouter"
+	"github.com/yuichiro-h/cwl-alert-notifier/receiver"
+	"github.com/yuichiro-h/cwl-alert-notifier/render"
+	"github.com/yuichiro-h/cwl-alert-notifier/silence"
 	"go.uber.org/zap"
 )
 
 type AlarmHandler struct {
-	alarm config.Alarm
+	name config.AlarmName
+	svc  *services
 }
 
-func NewAlarmHandler(alarm config.Alarm) func(ctx *sqsrouter.Context) {
+func NewAlarmHandler(name config.AlarmName, svc *services) receiver.Handler {
 	return (&AlarmHandler{
-		alarm: alarm,
+		name: name,
+		svc:  svc,
 	}).Handle
 }
 
-func (h *AlarmHandler) Handle(ctx *sqsrouter.Context) {
-	msg, err := ctx.GetSNSMessage()
-	if err != nil {
-		log.Get().Error(err.Error())
-		ctx.SetDeleteOnFinish(true)
+// Handle notifies the log events behind an alarm. ctx is canceled when the
+// shutdown timeout expires, which aborts in-flight API calls.
+func (h *AlarmHandler) Handle(ctx context.Context, m *receiver.Message) {
+	// 処理中に設定が再読み込みされても一貫するようにスナップショットを使う
+	conf := config.Get()
+	alarm, ok := conf.Alarms[h.name]
+	if !ok {
+		log.Get().Warn("alarm removed from config", zap.String("alarm", string(h.name)))
 		return
 	}
 
+	rec := newHistoryRecord(h.name, m)
+	err := h.handle(ctx, conf, alarm, m, rec)
+	if err == nil {
+		m.SetDeleteOnFinish(true)
+		h.saveHistory(ctx, conf, rec, rec.result(), nil)
+		return
+	}
+
+	if ctx.Err() != nil {
+		// シャットダウンで中断した場合は再配信に任せる
+		log.Get().Warn("aborted handling message",
+			zap.String("alarm", string(h.name)),
+			zap.String("message_id", aws.StringValue(m.MessageId)),
+			zap.Error(err))
+		return
+	}
+
+	receiveCount := m.ReceiveCount()
+	if !isPermanent(err) && receiveCount < conf.Retry.GetMaxReceives() {
+		log.Get().Warn("failed to handle message, will retry",
+			zap.String("alarm", string(h.name)),
+			zap.String("message_id", aws.StringValue(m.MessageId)),
+			zap.Int("receive_count", receiveCount),
+			zap.Error(err))
+		h.saveHistory(ctx, conf, rec, historyRetrying, err)
+		return
+	}
+
+	log.Get().Error("giving up message",
+		zap.String("alarm", string(h.name)),
+		zap.String("message_id", aws.StringValue(m.MessageId)),
+		zap.Int("receive_count", receiveCount),
+		zap.Bool("permanent", isPermanent(err)),
+		zap.Error(err))
+
+	if err := h.giveUp(ctx, conf, m, receiveCount, err); err != nil {
+		log.Get().Error("failed to give up message", zap.Error(err))
+		h.saveHistory(ctx, conf, rec, historyRetrying, err)
+		return
+	}
+	m.SetDeleteOnFinish(true)
+	h.saveHistory(ctx, conf, rec, historyFailed, err)
+}
+
+// saveHistory records the outcome of the message. A failure is only logged
+// since the notifications are done already.
+func (h *AlarmHandler) saveHistory(ctx context.Context, conf *config.Config, rec *historyRecord, status string, cause error) {
+	if err := h.svc.history.Save(ctx, conf, rec, status, cause); err != nil {
+		log.Get().Error("failed to record history", zap.String("message_id", rec.ID), zap.Error(err))
+	}
+}
+
+// giveUp forwards a poison message to the dead letter queue and reports it to
+// the ops channel, when they are configured.
+func (h *AlarmHandler) giveUp(ctx context.Context, conf *config.Config, m *receiver.Message, receiveCount int, cause error) error {
+	if url := conf.Retry.DeadLetterSqsURL; url != "" {
+		_, err := h.svc.sqsClient.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+			QueueUrl:    aws.String(url),
+			MessageBody: m.Body,
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				"Alarm":             stringAttribute(string(h.name)),
+				"Error":             stringAttribute(cause.Error()),
+				"Permanent":         stringAttribute(fmt.Sprint(isPermanent(cause))),
+				"ReceiveCount":      numberAttribute(receiveCount),
+				"OriginalMessageId": stringAttribute(aws.StringValue(m.MessageId)),
+			},
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if ops := conf.Retry.OpsSlack; ops.Channel != "" {
+		slack := conf.Slack
+		slack.Merge(ops)
+		text := fmt.Sprintf("Gave up alarm message `%s` of *%s* after %d receive(s)",
+			aws.StringValue(m.MessageId), h.name, receiveCount)
+		if err := notifyText(ctx, slack, text, cause.Error()); err != nil {
+			log.Get().Error("failed to notify ops channel", zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+func stringAttribute(v string) *sqs.MessageAttributeValue {
+	return &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(v),
+	}
+}
+
+func numberAttribute(v int) *sqs.MessageAttributeValue {
+	return &sqs.MessageAttributeValue{
+		DataType:    aws.String("Number"),
+		StringValue: aws.String(strconv.Itoa(v)),
+	}
+}
+
+// handle notifies the log events of m, filling rec with what was done.
+func (h *AlarmHandler) handle(ctx context.Context, conf *config.Config, alarm config.Alarm, m *receiver.Message, rec *historyRecord) error {
+	msg, err := m.GetSNSMessage()
+	if err != nil {
+		return permanent(err)
+	}
+
 	var cwAlarm CloudWatchAlarm
 	if err := json.Unmarshal([]byte(msg.Message), &cwAlarm); err != nil {
-		log.Get().Error(err.Error())
-		return
+		return permanent(errors.WithStack(err))
 	}
+	rec.CloudWatchAlarm = &cwAlarm
 
 	// ログの検索フィルターを取得
-	sess := session.Must(session.NewSession())
-	cwl := cloudwatchlogs.New(sess)
-	descMetricFiltersOut, err := cwl.DescribeMetricFilters(&cloudwatchlogs.DescribeMetricFiltersInput{
+	cwl := h.svc.cwl
+	descMetricFiltersOut, err := cwl.DescribeMetricFiltersWithContext(ctx, &cloudwatchlogs.DescribeMetricFiltersInput{
 		MetricNamespace: aws.String(cwAlarm.Trigger.Namespace),
 		MetricName:      aws.String(cwAlarm.Trigger.MetricName),
 	})
 	if err != nil {
-		log.Get().Error(err.Error())
-		return
+		return errors.WithStack(err)
+	}
+	if len(descMetricFiltersOut.MetricFilters) == 0 {
+		return permanent(errors.Errorf("not found metric filter. namespace=%s name=%s",
+			cwAlarm.Trigger.Namespace, cwAlarm.Trigger.MetricName))
 	}
 	filter := descMetricFiltersOut.MetricFilters[0]
 
@@ -64,29 +185,40 @@ func (h *AlarmHandler) Handle(ctx *sqsrouter.Context) {
 	// ログの取得範囲の算出
 	stateChangeTime, err := time.Parse("2006-01-02T15:04:05.999-0700", cwAlarm.StateChangeTime)
 	if err != nil {
-		log.Get().Error(err.Error())
-		return
+		return permanent(errors.WithStack(err))
 	}
 
 	logRangeDurationBefore := -3 * time.Minute
-	if config.Get().Log.RangeDuration.Before != nil {
-		logRangeDurationBefore = time.Duration(-*config.Get().Log.RangeDuration.Before) * time.Second
+	if conf.Log.RangeDuration.Before != nil {
+		logRangeDurationBefore = time.Duration(-*conf.Log.RangeDuration.Before) * time.Second
 	}
 	logRangeDurationAfter := 3 * time.Minute
-	if config.Get().Log.RangeDuration.After != nil {
-		logRangeDurationAfter = time.Duration(*config.Get().Log.RangeDuration.After) * time.Second
+	if conf.Log.RangeDuration.After != nil {
+		logRangeDurationAfter = time.Duration(*conf.Log.RangeDuration.After) * time.Second
 	}
 
 	startTime := stateChangeTime.Add(logRangeDurationBefore).UTC()
 	endTime := stateChangeTime.Add(logRangeDurationAfter).UTC()
+	rec.Filter = &historyFilter{
+		LogGroupName:  *filter.LogGroupName,
+		FilterPattern: *filter.FilterPattern,
+		StartTime:     startTime,
+		EndTime:       endTime,
+	}
+
+	maxEvents := 1000
+	if conf.Log.MaxEvents != nil {
+		maxEvents = *conf.Log.MaxEvents
+	}
 
 	// ログを取得
 	var events []*cloudwatchlogs.FilteredLogEvent
+	var truncated bool
 	var nextToken *string
 	for {
 		var out *cloudwatchlogs.FilterLogEventsOutput
 		err := backoff.Retry(func() error {
-			out, err = cwl.FilterLogEvents(&cloudwatchlogs.FilterLogEventsInput{
+			out, err = cwl.FilterLogEventsWithContext(ctx, &cloudwatchlogs.FilterLogEventsInput{
 				LogGroupName:  filter.LogGroupName,
 				FilterPattern: filter.FilterPattern,
 				StartTime:     aws.Int64(startTime.UnixNano() / int64(time.Millisecond)),
@@ -99,10 +231,9 @@ func (h *AlarmHandler) Handle(ctx *sqsrouter.Context) {
 			}
 
 			return nil
-		}, backoff.NewExponentialBackOff())
+		}, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
 		if err != nil {
-			log.Get().Error(err.Error())
-			return
+			return errors.WithStack(err)
 		}
 
 		if len(out.Events) > 0 {
@@ -110,11 +241,19 @@ func (h *AlarmHandler) Handle(ctx *sqsrouter.Context) {
 		}
 
 		nextToken = out.NextToken
+		if len(events) >= maxEvents {
+			// 大量のエラーで通知が遅れないように上限で打ち切る
+			truncated = len(events) > maxEvents || nextToken != nil
+			events = events[:maxEvents]
+			break
+		}
 		if nextToken == nil || len(out.Events) == 0 {
 			break
 		}
 	}
 
+	rec.EventCount = len(events)
+	rec.Truncated = truncated
 	if len(events) == 0 {
 		log.Get().Warn("not found alarm event",
 			zap.String("metric_namespace", cwAlarm.Trigger.Namespace),
@@ -123,72 +262,274 @@ func (h *AlarmHandler) Handle(ctx *sqsrouter.Context) {
 			zap.String("filter", *filter.FilterPattern),
 			zap.String("state_change_time", cwAlarm.StateChangeTime))
 
-		ctx.SetDeleteOnFinish(true)
-		return
+		return nil
+	}
+
+	log.Get().Info("get log event", zap.Int("count", len(events)), zap.Bool("truncated", truncated))
+
+	// 全てのログを表示するCloudWatchコンソールのURL
+	consoleURL := fmt.Sprintf("https://%s.console.aws.amazon.com/cloudwatch/home?region=%s#logEventViewer:group=%s;filter=%s;start=%s;end=%s",
+		conf.AWS.Region, conf.AWS.Region, *filter.LogGroupName, url.QueryEscape(*filter.FilterPattern),
+		startTime.Format(time.RFC3339), endTime.Format(time.RFC3339))
+
+	notifyInputs, err := h.collect(ctx, conf, alarm, &alarmEvents{
+		alarm:        &cwAlarm,
+		logGroupName: *filter.LogGroupName,
+		events:       events,
+		truncated:    truncated,
+		consoleURL:   consoleURL,
+	}, rec)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	// 重要度に従って通知方法を決める
+	var tags map[string]string
+	if conf.Severity.UsesAlarmTags() {
+		arn := cwAlarm.AlarmArn
+		if arn == "" {
+			arn = fmt.Sprintf("arn:aws:cloudwatch:%s:%s:alarm:%s", conf.AWS.Region, cwAlarm.AWSAccountID, cwAlarm.AlarmName)
+		}
+		if tags, err = h.svc.alarmTags.Get(ctx, arn); err != nil {
+			log.Get().Warn("failed to get alarm tags", zap.String("alarm_arn", arn), zap.Error(err))
+		}
+	}
+	for i := range notifyInputs {
+		n := &notifyInputs[i]
+		var levels []string
+		for _, e := range n.Events {
+			if e.Level != "" {
+				levels = append(levels, e.Level)
+			}
+		}
+
+		n.Severity = conf.Severity.Of(cwAlarm.AlarmName, tags, levels)
+		level := conf.Severity.Level(n.Severity)
+		n.Slack.Merge(level.Slack)
+		n.Slack.Merge(n.RouteSlack)
+		if n.Slack.AttachmentColor == "" {
+			n.Slack.AttachmentColor = config.SeverityColor(n.Severity)
+		}
+		n.Mentions = h.svc.mentions.Resolve(ctx, n.Slack.ApiToken, append(append([]string{}, level.Mentions...), n.Mentions...))
+		n.Delivery = level.Delivery
+		n.Sinks = level.Sinks
+	}
+
+	for i := range notifyInputs {
+		n := &notifyInputs[i]
+		var sent bool
+		for _, sink := range n.Sinks {
+			var status string
+			var err error
+			switch sink {
+			case config.SinkPagerDuty:
+				status, err = h.deliverPagerDuty(ctx, conf, msg.MessageID, n)
+			default:
+				sink = n.Sink()
+				status, err = h.deliverSlack(ctx, conf, msg.MessageID, n, now)
+			}
+			if err != nil {
+				rec.addDelivery(n, sink, deliveryFailed, err)
+				return err
+			}
+			rec.addDelivery(n, sink, status, nil)
+			sent = sent || status != deliveryAlreadySent
+		}
+
+		if sent {
+			if err := h.svc.stats.Record(ctx, n, now); err != nil {
+				log.Get().Error("failed to record alert stats", zap.Error(err))
+			}
+		}
 	}
 
-	log.Get().Info("get log event", zap.Int("count", len(events)))
+	return nil
+}
+
+// alarmEvents are the log events found behind an alarm.
+type alarmEvents struct {
+	alarm        *CloudWatchAlarm
+	logGroupName string
+	events       []*cloudwatchlogs.FilteredLogEvent
+	// truncated is set when more events matched than were fetched.
+	truncated  bool
+	consoleURL string
+}
+
+// collect groups the log events of ae into notifications per application,
+// leaving out silenced events and those in maintenance windows.
+func (h *AlarmHandler) collect(ctx context.Context, conf *config.Config, alarm config.Alarm, ae *alarmEvents, rec *historyRecord) ([]notifyInput, error) {
+	silences, err := h.svc.silencer.List(ctx, conf.Silences.Rules)
+	if err != nil {
+		return nil, err
+	}
+	maxDisplayedEvents := 20
+	if conf.Log.MaxDisplayedEvents != nil {
+		maxDisplayedEvents = *conf.Log.MaxDisplayedEvents
+	}
+	redactor := conf.Redaction.Redactor()
+	now := time.Now()
+	suppressed := map[string]int{}
+	var windowSuppressed int
+	// 複数行にまたがるレコードは一度だけ通知する
+	reassembled := map[string]bool{}
+	// アプリケーションごとのエラーの種類
+	fingerprints := map[string]map[string]bool{}
+	// 前後のログを取得済みのイベントを、アプリケーションと取得前の内容の種類ごとに覚えておく。
+	// nil は通知済みのレコードの一部だったことを表す
+	expanded := map[string]*streamRecord{}
 
 	// ログを通知
 	var notifyInputs []notifyInput
-	for _, e := range events {
+	for _, e := range ae.events {
 		var appName string
+		var group *config.AlarmGroup
 
-		// Slackへの通知設定を取得
-		slack := config.Get().Slack
-
-		if *filter.LogGroupName == "/aws/batch/job" {
+		if ae.logGroupName == "/aws/batch/job" {
 			// AWS Batchのログストリーム名は{jobDefinitionName}/default/{ecs_task_id}の形式
 			jobDefinitionName := strings.Split(*e.LogStreamName, "/")[0]
 			appName = fmt.Sprintf("%s(AWS Batch)", jobDefinitionName)
 
 		L1:
-			for _, g := range h.alarm.Groups {
+			for i, g := range alarm.Groups {
 				for _, def := range g.AWSBatchJobDefinitions {
 					if glob.MustCompile(def).Match(jobDefinitionName) {
-						slack.Merge(h.alarm.Slack)
-						slack.Merge(g.Slack)
+						group = &alarm.Groups[i]
 						break L1
 					}
 				}
 			}
 		} else {
-			appName = *filter.LogGroupName
+			appName = ae.logGroupName
 
 		L2:
-			for _, g := range h.alarm.Groups {
+			for i, g := range alarm.Groups {
 				for _, lg := range g.LogGroups {
 					if glob.MustCompile(lg).Match(appName) {
-						slack.Merge(h.alarm.Slack)
-						slack.Merge(g.Slack)
+						group = &alarm.Groups[i]
 						break L2
 					}
 				}
 			}
 		}
 
+		rec.addApplication(appName)
+
+		// Slackへの通知設定を取得
+		slack := conf.EffectiveSlack(alarm, group)
+
+		// サイレンス中のイベントは通知しない
+		if s := silence.Match(silences, silence.Event{
+			AlarmName: ae.alarm.AlarmName,
+			AppName:   appName,
+			LogGroup:  ae.logGroupName,
+			Message:   *e.Message,
+		}, now); s != nil {
+			suppressed[s.ID]++
+			continue
+		}
+
+		// メンテナンス時間帯・静粛時間帯の設定に従う
+		var digestUntil time.Time
+		var routeSlack config.SlackConfig
+		if w, end := config.ActiveWindow(alarm.Windows(group), now); w != nil {
+			switch w.GetAction() {
+			case config.WindowActionSuppress:
+				windowSuppressed++
+				continue
+			case config.WindowActionRoute:
+				routeSlack = w.Slack
+			case config.WindowActionDigest:
+				digestUntil = end
+			}
+		}
+
+		// スタックトレースなど1行ずつ出力されたログを結合し、前後の行を添える
+		raw := *e.Message
+		var before, after []string
+		mc := conf.EffectiveMultiline(alarm, group)
+		var contextBefore, contextAfter int
+		if group != nil {
+			contextBefore, contextAfter = group.ContextBefore, group.ContextAfter
+		}
+		key := appName + "\x00" + fingerprint.Of(raw)
+		record, cached := expanded[key]
+		switch {
+		case !mc.Enabled() && contextBefore == 0 && contextAfter == 0:
+		case cached:
+			// 同じ種類のエラーは最初のイベントだけが表示されるので、取得し直さずに件数だけ数える
+			if record == nil {
+				continue
+			}
+			raw = record.Message
+			before, after = record.Before, record.After
+		case len(fingerprints[appName]) >= maxDisplayedEvents:
+			// 表示されないイベントは前後のログを取得しない
+		default:
+			record, err := expandEvent(ctx, h.svc.cwl, ae.logGroupName, e, mc, contextBefore, contextAfter)
+			switch {
+			case err != nil:
+				log.Get().Warn("failed to fetch log stream around event",
+					zap.String("log_stream_name", *e.LogStreamName),
+					zap.Error(err))
+				// 取得できなかった種類のエラーも繰り返し取得しない
+				expanded[key] = &streamRecord{Message: raw}
+			case reassembled[record.Key]:
+				expanded[key] = nil
+				continue
+			default:
+				reassembled[record.Key] = true
+				expanded[key] = record
+				raw = record.Message
+				before, after = record.Before, record.After
+			}
+		}
+
 		// ログイベント発生日時
 		eventAt := time.Unix(*e.Timestamp/1000, 0).In(time.Local)
 
-		// ログ内容
-		var body string
-		var msg map[string]interface{}
-		if err := json.Unmarshal([]byte(*e.Message), &msg); err != nil {
-			body = *e.Message
-		} else {
-			data, err := json.MarshalIndent(&msg, "", "    ")
+		// ログ内容は通知先に渡る前に秘匿情報を伏せる
+		message := redactor.Redact(raw)
+		event := render.Event{
+			Message:       message,
+			Body:          message,
+			Before:        redactor.RedactAll(before),
+			After:         redactor.RedactAll(after),
+			Timestamp:     eventAt,
+			LogStreamName: *e.LogStreamName,
+		}
+		if err := json.Unmarshal([]byte(message), &event.Fields); err == nil {
+			data, err := json.MarshalIndent(&event.Fields, "", "    ")
 			if err != nil {
-				log.Get().Error(err.Error())
-				return
+				return nil, permanent(errors.WithStack(err))
 			}
-			body = string(data)
+			event.Body = string(data)
+			event.Extract(conf.EffectiveFields(alarm, group).Mapping())
 		}
 
+		// エラーの種類を指定したサイレンスは通知内容から判定する
+		if s := silence.Match(silences, silence.Event{
+			AlarmName:   ae.alarm.AlarmName,
+			AppName:     appName,
+			LogGroup:    ae.logGroupName,
+			Message:     raw,
+			Fingerprint: event.Fingerprint(),
+		}, now); s != nil {
+			suppressed[s.ID]++
+			continue
+		}
+
+		if fingerprints[appName] == nil {
+			fingerprints[appName] = map[string]bool{}
+		}
+		fingerprints[appName][event.Fingerprint()] = true
+
 		log.Get().Debug("get log event",
 			zap.String("app_name", appName),
 			zap.String("log_stream_name", *e.LogStreamName),
 			zap.Any("slack", slack),
-			zap.String("msg", *e.Message),
+			zap.String("msg", message),
 			zap.Time("event_at", eventAt))
 
 		var exists bool
@@ -196,7 +537,7 @@ func (h *AlarmHandler) Handle(ctx *sqsrouter.Context) {
 			// 一度の通知で同一のジョブ定義のエラーがある場合は
 			// 先頭のログ移行はログ内容のみを通知する
 			if n.ApplicationName == appName {
-				notifyInputs[i].Body = append(notifyInputs[i].Body, string(body))
+				notifyInputs[i].Events = append(notifyInputs[i].Events, event)
 				exists = true
 				break
 			}
@@ -207,26 +548,137 @@ func (h *AlarmHandler) Handle(ctx *sqsrouter.Context) {
 
 		// CloudWatchコンソールのURLを組み立て
 		urlBuilder := strings.Builder{}
-		urlBuilder.WriteString(fmt.Sprintf("https://%s.console.aws.amazon.com/cloudwatch/home?", config.Get().AWS.Region))
-		urlBuilder.WriteString(fmt.Sprintf("region=%s", config.Get().AWS.Region))
-		urlBuilder.WriteString(fmt.Sprintf("#logEventViewer:group=%s;", *filter.LogGroupName))
+		urlBuilder.WriteString(fmt.Sprintf("https://%s.console.aws.amazon.com/cloudwatch/home?", conf.AWS.Region))
+		urlBuilder.WriteString(fmt.Sprintf("region=%s", conf.AWS.Region))
+		urlBuilder.WriteString(fmt.Sprintf("#logEventViewer:group=%s;", ae.logGroupName))
 		urlBuilder.WriteString(fmt.Sprintf("stream=%s;", *e.LogStreamName))
 		urlBuilder.WriteString(fmt.Sprintf("start=%s", eventAt.UTC().Format(time.RFC3339)))
 
 		notifyInputs = append(notifyInputs, notifyInput{
+			AlarmName:       h.name,
+			GroupIndex:      groupIndex(alarm, group),
+			DigestUntil:     digestUntil,
+			Alarm:           ae.alarm,
 			ApplicationName: appName,
+			LogGroupName:    ae.logGroupName,
 			Slack:           slack,
+			Templates:       conf.EffectiveTemplates(alarm, group),
 			FirstLogURL:     urlBuilder.String(),
-			Body:            []string{string(body)},
+			ConsoleURL:      ae.consoleURL,
+			Events:          []render.Event{event},
+			MaxDisplayed:    maxDisplayedEvents,
+			Truncated:       ae.truncated,
+			RouteSlack:      routeSlack,
+			Mentions:        conf.Mentions(alarm, group, now),
 		})
 	}
 
-	for _, n := range notifyInputs {
-		if err := notify(&n); err != nil {
-			log.Get().Error(err.Error())
-			return
+	for _, s := range silences {
+		n, ok := suppressed[s.ID]
+		if !ok {
+			continue
+		}
+		log.Get().Info("suppressed log event",
+			zap.String("silence_id", s.ID),
+			zap.String("silence", s.String()),
+			zap.Int("count", n))
+		rec.Suppressed += n
+		if err := h.svc.silencer.AddSuppressed(ctx, s, n); err != nil {
+			log.Get().Error("failed to record suppressed count", zap.Error(err))
+		}
+	}
+
+	rec.Suppressed += windowSuppressed
+	if windowSuppressed > 0 {
+		log.Get().Info("suppressed log event in maintenance window", zap.Int("count", windowSuppressed))
+	}
+
+	return notifyInputs, nil
+}
+
+// deliverSlack posts n to Slack unless it was delivered already, and returns
+// the delivery status.
+func (h *AlarmHandler) deliverSlack(ctx context.Context, conf *config.Config, messageID string, n *notifyInput, now time.Time) (string, error) {
+	// 再配信時に送信済みの通知を繰り返さない
+	id := notificationID(messageID, n.ApplicationName, n.Sink())
+	delivered, err := h.svc.deliveries.Delivered(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if delivered {
+		log.Get().Info("skip delivered notification",
+			zap.String("app_name", n.ApplicationName),
+			zap.String("sink", n.Sink()))
+		return deliveryAlreadySent, nil
+	}
+
+	status := deliveryDeferred
+	switch {
+	case n.Delivery == config.DeliveryDigest:
+		// 重要度の低い通知はダイジェストでのみ報告する
+		log.Get().Info("notification left to digests",
+			zap.String("app_name", n.ApplicationName),
+			zap.String("severity", n.Severity))
+		status = deliveryDigest
+	case !n.DigestUntil.IsZero():
+		// 時間帯の終了時にまとめて通知する
+		err = addDeferred(ctx, h.svc.store, id, deferredByWindow, n.DigestUntil, n)
+	case !h.svc.allow(conf, n.Sink(), now):
+		// 流量制限を超えた通知は次の集約時にまとめて通知する
+		log.Get().Info("rate limited notification",
+			zap.String("app_name", n.ApplicationName),
+			zap.String("sink", n.Sink()))
+		err = addDeferred(ctx, h.svc.store, id, deferredByRateLimit, nextRateLimitFlush(conf, now), n)
+	case conf.HTTP.SlackSigningSecret != "":
+		// Slackアプリが設定されていれば操作ボタンを付ける
+		var actions []slack.AttachmentAction
+		actions, err = addActions(ctx, h.svc.store, id, n)
+		if err == nil {
+			err = notify(ctx, n, id, actions)
 		}
+		status = deliverySent
+	default:
+		err = notify(ctx, n, "", nil)
+		status = deliverySent
 	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := h.svc.deliveries.MarkDelivered(ctx, id); err != nil {
+		log.Get().Error("failed to record delivery", zap.Error(err))
+	}
+	return status, nil
+}
+
+// deliverPagerDuty triggers a PagerDuty incident for n unless it was triggered
+// already, and returns the delivery status.
+func (h *AlarmHandler) deliverPagerDuty(ctx context.Context, conf *config.Config, messageID string, n *notifyInput) (string, error) {
+	id := notificationID(messageID, n.ApplicationName, config.SinkPagerDuty)
+	delivered, err := h.svc.deliveries.Delivered(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if delivered {
+		return deliveryAlreadySent, nil
+	}
+
+	if err := triggerPagerDuty(ctx, conf.EffectivePagerDuty(n.Severity), id, n); err != nil {
+		return "", err
+	}
+
+	if err := h.svc.deliveries.MarkDelivered(ctx, id); err != nil {
+		log.Get().Error("failed to record delivery", zap.Error(err))
+	}
+	return deliverySent, nil
+}
 
-	ctx.SetDeleteOnFinish(true)
+// groupIndex returns the index of group in alarm, or -1 for nil.
+func groupIndex(alarm config.Alarm, group *config.AlarmGroup) int {
+	for i := range alarm.Groups {
+		if &alarm.Groups[i] == group {
+			return i
+		}
+	}
+	return -1
 }
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/receiver"
+	"github.com/yuichiro-h/cwl-alert-notifier/render"
+	"github.com/yuichiro-h/cwl-alert-notifier/silence"
+	"github.com/yuichiro-h/cwl-alert-notifier/state"
+)
+
+// fakeCWL serves GetLogEvents from log streams of one event per millisecond,
+// or at the timestamps in times when a stream has them.
+type fakeCWL struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+
+	streams map[string][]string
+	times   map[string][]int64
+	// describeErr fails DescribeMetricFilters when set.
+	describeErr error
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (f *fakeCWL) GetLogEventsWithContext(_ aws.Context, in *cloudwatchlogs.GetLogEventsInput, _ ...request.Option) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+
+	var events []*cloudwatchlogs.OutputLogEvent
+	stream := aws.StringValue(in.LogStreamName)
+	for i, msg := range f.streams[stream] {
+		ts := f.timestamp(stream, i)
+		if in.StartTime != nil && ts < *in.StartTime || in.EndTime != nil && ts >= *in.EndTime {
+			continue
+		}
+		events = append(events, &cloudwatchlogs.OutputLogEvent{Timestamp: aws.Int64(ts), Message: aws.String(msg)})
+	}
+	limit := int(aws.Int64Value(in.Limit))
+	if len(events) > limit {
+		if aws.BoolValue(in.StartFromHead) {
+			events = events[:limit]
+		} else {
+			events = events[len(events)-limit:]
+		}
+	}
+	return &cloudwatchlogs.GetLogEventsOutput{Events: events}, nil
+}
+
+func (f *fakeCWL) DescribeMetricFiltersWithContext(aws.Context, *cloudwatchlogs.DescribeMetricFiltersInput, ...request.Option) (*cloudwatchlogs.DescribeMetricFiltersOutput, error) {
+	if f.describeErr != nil {
+		return nil, f.describeErr
+	}
+	return &cloudwatchlogs.DescribeMetricFiltersOutput{}, nil
+}
+
+func (f *fakeCWL) timestamp(stream string, i int) int64 {
+	if times, ok := f.times[stream]; ok {
+		return times[i]
+	}
+	return int64(i)
+}
+
+func (f *fakeCWL) fetches() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// matched returns the events of stream at indexes as matched by a metric filter.
+func (f *fakeCWL) matched(stream string, indexes ...int) []*cloudwatchlogs.FilteredLogEvent {
+	var events []*cloudwatchlogs.FilteredLogEvent
+	for _, i := range indexes {
+		events = append(events, &cloudwatchlogs.FilteredLogEvent{
+			LogStreamName: aws.String(stream),
+			Timestamp:     aws.Int64(f.timestamp(stream, i)),
+			Message:       aws.String(f.streams[stream][i]),
+		})
+	}
+	return events
+}
+
+func newTestHandler(cwl *fakeCWL) *AlarmHandler {
+	return &AlarmHandler{
+		name: "alarm",
+		svc: &services{
+			cwl:      cwl,
+			silencer: silence.New(state.NewMemoryStore()),
+		},
+	}
+}
+
+func TestCollectRedactsContextLines(t *testing.T) {
+	cwl := &fakeCWL{streams: map[string][]string{"s": {
+		"start",
+		"Authorization: Bearer abc.def",
+		"ERROR mail to a@example.com failed",
+		"card 4111 1111 1111 1111",
+		"end",
+	}}}
+	alarm := config.Alarm{Groups: []config.AlarmGroup{
+		{LogGroups: []string{"/app"}, ContextBefore: 1, ContextAfter: 1},
+	}}
+	conf := &config.Config{Alarms: map[config.AlarmName]config.Alarm{"alarm": alarm}}
+
+	inputs, err := newTestHandler(cwl).collect(context.Background(), conf, alarm, &alarmEvents{
+		alarm:        &CloudWatchAlarm{AlarmName: "alarm"},
+		logGroupName: "/app",
+		events:       cwl.matched("s", 2),
+	}, &historyRecord{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 1 || len(inputs[0].Events) != 1 {
+		t.Fatalf("collect() = %+v, want one event", inputs)
+	}
+
+	e := inputs[0].Events[0]
+	if want := "ERROR mail to [REDACTED:email] failed"; e.Message != want {
+		t.Errorf("Message = %q, want %q", e.Message, want)
+	}
+	if want := []string{"Authorization: Bearer [REDACTED:bearer_token]"}; !reflect.DeepEqual(e.Before, want) {
+		t.Errorf("Before = %q, want %q", e.Before, want)
+	}
+	if want := []string{"card [REDACTED:credit_card]"}; !reflect.DeepEqual(e.After, want) {
+		t.Errorf("After = %q, want %q", e.After, want)
+	}
+}
+
+func TestCollectRedactionDisabled(t *testing.T) {
+	cwl := &fakeCWL{streams: map[string][]string{"s": {"a@example.com", "ERROR", "b@example.com"}}}
+	alarm := config.Alarm{Groups: []config.AlarmGroup{
+		{LogGroups: []string{"/app"}, ContextBefore: 1, ContextAfter: 1},
+	}}
+	conf := &config.Config{}
+	conf.Redaction.Disabled = true
+
+	inputs, err := newTestHandler(cwl).collect(context.Background(), conf, alarm, &alarmEvents{
+		alarm:        &CloudWatchAlarm{AlarmName: "alarm"},
+		logGroupName: "/app",
+		events:       cwl.matched("s", 1),
+	}, &historyRecord{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := inputs[0].Events[0]
+	if got := fmt.Sprint(e.Before, e.After); got != "[a@example.com] [b@example.com]" {
+		t.Errorf("context = %s, want the lines verbatim", got)
+	}
+}
+
+func TestCollectFetchesOnlyDisplayedEvents(t *testing.T) {
+	var repeated, distinct []string
+	for i := 0; i < 1000; i++ {
+		repeated = append(repeated, fmt.Sprintf("ERROR timeout after %dms", i))
+	}
+	for i := 0; i < 100; i++ {
+		distinct = append(distinct, fmt.Sprintf("ERROR %s failed", string(rune('A'+i%26))+string(rune('a'+i/26))))
+	}
+	indexes := func(n int) []int {
+		var is []int
+		for i := 0; i < n; i++ {
+			is = append(is, i)
+		}
+		return is
+	}
+
+	tests := []struct {
+		name        string
+		stream      []string
+		wantFetches int
+		wantEvents  int
+		wantGroups  int
+	}{
+		// 同じエラーが繰り返されても前後のログは最初の1件だけ取得する
+		{"repeated error", repeated, 2, 1000, 1},
+		// 種類の異なるエラーは表示される5件だけ取得する
+		{"distinct errors", distinct, 10, 100, 100},
+	}
+	for _, tt := range tests {
+		cwl := &fakeCWL{streams: map[string][]string{"s": tt.stream}}
+		alarm := config.Alarm{Groups: []config.AlarmGroup{
+			{LogGroups: []string{"/app"}, ContextBefore: 1, ContextAfter: 1},
+		}}
+		conf := &config.Config{}
+		maxDisplayed := 5
+		conf.Log.MaxDisplayedEvents = &maxDisplayed
+
+		inputs, err := newTestHandler(cwl).collect(context.Background(), conf, alarm, &alarmEvents{
+			alarm:        &CloudWatchAlarm{AlarmName: "alarm"},
+			logGroupName: "/app",
+			events:       cwl.matched("s", indexes(len(tt.stream))...),
+		}, &historyRecord{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cwl.fetches(); got != tt.wantFetches {
+			t.Errorf("%s: fetched %d times, want %d", tt.name, got, tt.wantFetches)
+		}
+		if len(inputs) != 1 {
+			t.Fatalf("%s: collect() returned %d notifications, want 1", tt.name, len(inputs))
+		}
+		if got := len(inputs[0].Events); got != tt.wantEvents {
+			t.Errorf("%s: %d events, want %d", tt.name, got, tt.wantEvents)
+		}
+		clusters := render.Cluster(inputs[0].Events)
+		if len(clusters) != tt.wantGroups {
+			t.Errorf("%s: %d clusters, want %d", tt.name, len(clusters), tt.wantGroups)
+		}
+		for i, c := range clusters {
+			if i < maxDisplayed && len(c.After) == 0 {
+				t.Errorf("%s: displayed event %q has no context", tt.name, c.Message)
+			}
+		}
+	}
+}
+
+func TestCollectMultilineRepeated(t *testing.T) {
+	var stream []string
+	for i := 0; i < 50; i++ {
+		stream = append(stream, fmt.Sprintf("ERROR job %d failed", i), "  caused by ERROR timeout")
+	}
+	cwl := &fakeCWL{streams: map[string][]string{"s": stream}}
+	var all []int
+	for i := range stream {
+		all = append(all, i)
+	}
+	alarm := config.Alarm{Groups: []config.AlarmGroup{{
+		LogGroups: []string{"/app"},
+		Multiline: config.MultilineConfig{Continuation: `^\s`},
+	}}}
+
+	inputs, err := newTestHandler(cwl).collect(context.Background(), &config.Config{}, alarm, &alarmEvents{
+		alarm:        &CloudWatchAlarm{AlarmName: "alarm"},
+		logGroupName: "/app",
+		events:       cwl.matched("s", all...),
+	}, &historyRecord{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1件目のレコードと、その一部だった行の2回分だけ取得する
+	if got := cwl.fetches(); got != 4 {
+		t.Errorf("fetched %d times, want 4", got)
+	}
+	clusters := render.Cluster(inputs[0].Events)
+	if len(clusters) != 1 || clusters[0].Count != 50 {
+		t.Fatalf("clusters = %+v, want one of 50 records", clusters)
+	}
+	if want := "ERROR job 0 failed\n  caused by ERROR timeout"; clusters[0].Message != want {
+		t.Errorf("Message = %q, want %q", clusters[0].Message, want)
+	}
+}
+
+// fakeSQS records the messages sent to dead letter queues.
+type fakeSQS struct {
+	sqsiface.SQSAPI
+
+	sendErr error
+	sent    []*sqs.SendMessageInput
+}
+
+func (f *fakeSQS) SendMessageWithContext(_ aws.Context, in *sqs.SendMessageInput, _ ...request.Option) (*sqs.SendMessageOutput, error) {
+	if f.sendErr != nil {
+		return nil, f.sendErr
+	}
+	f.sent = append(f.sent, in)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+func newTestMessage(id, body string, receiveCount int) *receiver.Message {
+	return &receiver.Message{Message: &sqs.Message{
+		MessageId: aws.String(id),
+		Body:      aws.String(body),
+		Attributes: map[string]*string{
+			sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String(strconv.Itoa(receiveCount)),
+		},
+	}}
+}
+
+func TestHandleGiveUp(t *testing.T) {
+	const base = `aws:
+  region: ap-northeast-1
+slack:
+  api_token: xoxb-token
+  channel: "#alerts"
+alarms:
+  a:
+    sqs_url: https://sqs.ap-northeast-1.amazonaws.com/123456789012/a
+retry:
+  max_receives: 3
+  ops_slack:
+    channel: "#ops"
+`
+	const dlq = "https://sqs.ap-northeast-1.amazonaws.com/123456789012/dlq"
+	alarmBody := `{"Message":"{\"AlarmName\":\"a\",\"Trigger\":{\"MetricName\":\"errors\",\"Namespace\":\"app\"}}"}`
+
+	tests := []struct {
+		name         string
+		dlq          bool
+		body         string
+		receiveCount int
+		sendErr      error
+		// wantPermanent is the Permanent attribute sent to the dead letter
+		// queue, empty when nothing is sent.
+		wantPermanent string
+		wantOps       bool
+		wantStatus    string
+	}{
+		{"permanent", true, "not json", 1, nil, "true", true, historyFailed},
+		{"transient under the limit", true, alarmBody, 2, nil, "", false, historyRetrying},
+		{"transient at the limit", true, alarmBody, 3, nil, "false", true, historyFailed},
+		{"transient over the limit", true, alarmBody, 4, nil, "false", true, historyFailed},
+		{"no dead letter queue", false, alarmBody, 3, nil, "", true, historyFailed},
+		{"dead letter queue failed", true, "not json", 1, errors.New("throttled"), "", false, historyRetrying},
+	}
+	for _, tt := range tests {
+		conf := base
+		if tt.dlq {
+			conf += "  dead_letter_sqs_url: " + dlq + "\n"
+		}
+		loadConfig(t, conf)
+		api, restore := newFakeSlack(t)
+
+		sqsClient := &fakeSQS{sendErr: tt.sendErr}
+		svc := newTestServices()
+		svc.sqsClient = sqsClient
+		svc.cwl = &fakeCWL{describeErr: errors.New("connection reset")}
+		m := newTestMessage("m1", tt.body, tt.receiveCount)
+		NewAlarmHandler("a", svc)(context.Background(), m)
+		restore()
+
+		var permanent string
+		if len(sqsClient.sent) > 0 {
+			in := sqsClient.sent[0]
+			attr := func(name string) string { return aws.StringValue(in.MessageAttributes[name].StringValue) }
+			permanent = attr("Permanent")
+			if aws.StringValue(in.QueueUrl) != dlq || aws.StringValue(in.MessageBody) != tt.body {
+				t.Errorf("%s: sent %v, want the message to the dead letter queue", tt.name, in)
+			}
+			if attr("Alarm") != "a" || attr("OriginalMessageId") != "m1" ||
+				attr("ReceiveCount") != strconv.Itoa(tt.receiveCount) || attr("Error") == "" {
+				t.Errorf("%s: attributes = %v", tt.name, in.MessageAttributes)
+			}
+		}
+		if permanent != tt.wantPermanent || len(sqsClient.sent) > 1 {
+			t.Errorf("%s: sent %d message(s) with Permanent %q, want %q", tt.name, len(sqsClient.sent), permanent, tt.wantPermanent)
+		}
+
+		posts := api.Posts()
+		if tt.wantOps {
+			want := fmt.Sprintf("Gave up alarm message `m1` of *a* after %d receive(s)", tt.receiveCount)
+			if len(posts) != 1 || posts[0].Get("channel") != "#ops" || posts[0].Get("text") != want {
+				t.Errorf("%s: posts = %v, want %q to #ops", tt.name, posts, want)
+			}
+		} else if len(posts) != 0 {
+			t.Errorf("%s: posts = %v, want none", tt.name, posts)
+		}
+
+		items, err := svc.store.List(context.Background(), "history/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rec historyRecord
+		if len(items) != 1 || json.Unmarshal(items[0].Value, &rec) != nil || rec.Status != tt.wantStatus {
+			t.Errorf("%s: history = %+v, want status %q", tt.name, rec, tt.wantStatus)
+		}
+	}
+}
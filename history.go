@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/gobwas/glob"
+	"github.com/pkg/errors"
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/log"
+	"github.com/yuichiro-h/cwl-alert-notifier/receiver"
+	"github.com/yuichiro-h/cwl-alert-notifier/state"
+	"go.uber.org/zap"
+)
+
+// Statuses of a processed alarm message.
+const (
+	// historyNotified means at least one notification was delivered or deferred.
+	historyNotified = "notified"
+	// historySuppressed means every event was silenced or in a maintenance window.
+	historySuppressed = "suppressed"
+	historyNoEvents   = "no_events"
+	// historyRetrying means the message was left in the queue for redelivery.
+	historyRetrying = "retrying"
+	// historyFailed means the message was given up.
+	historyFailed = "failed"
+)
+
+// Statuses of a notification on a sink.
+const (
+	deliverySent        = "sent"
+	deliveryAlreadySent = "already_sent"
+	// deliveryDeferred is a notification left to a window digest or to the
+	// aggregation of rate limited notifications.
+	deliveryDeferred = "deferred"
+	// deliveryDigest is a notification reported only by digests.
+	deliveryDigest = "digest"
+	deliveryFailed = "failed"
+)
+
+// historyRecord is what was done for an alarm message. Redeliveries of the
+// message overwrite the record.
+type historyRecord struct {
+	ID           string           `json:"id"`
+	Alarm        config.AlarmName `json:"alarm"`
+	ReceivedAt   time.Time        `json:"received_at"`
+	ProcessedAt  time.Time        `json:"processed_at"`
+	ReceiveCount int              `json:"receive_count"`
+	Status       string           `json:"status"`
+	Error        string           `json:"error,omitempty"`
+
+	CloudWatchAlarm *CloudWatchAlarm `json:"cloudwatch_alarm,omitempty"`
+	Filter          *historyFilter   `json:"filter,omitempty"`
+	EventCount      int              `json:"event_count"`
+	Truncated       bool             `json:"truncated,omitempty"`
+	// Suppressed counts events muted by silences and maintenance windows.
+	Suppressed int `json:"suppressed"`
+	// Applications are all applications of the events, including suppressed ones.
+	Applications  []string              `json:"applications"`
+	Notifications []historyNotification `json:"notifications"`
+}
+
+// historyFilter is the metric filter resolved from the alarm and the searched range.
+type historyFilter struct {
+	LogGroupName  string    `json:"log_group_name"`
+	FilterPattern string    `json:"filter_pattern"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+}
+
+type historyNotification struct {
+	ApplicationName string            `json:"application_name"`
+	Severity        string            `json:"severity"`
+	EventCount      int               `json:"event_count"`
+	Deliveries      []historyDelivery `json:"deliveries"`
+}
+
+type historyDelivery struct {
+	Sink   string `json:"sink"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// newHistoryRecord starts the record of m. It is identified by the SNS
+// message, which stays the same across redeliveries.
+func newHistoryRecord(name config.AlarmName, m *receiver.Message) *historyRecord {
+	rec := &historyRecord{
+		ID:           aws.StringValue(m.MessageId),
+		Alarm:        name,
+		ReceivedAt:   time.Now(),
+		ReceiveCount: m.ReceiveCount(),
+	}
+	if msg, err := m.GetSNSMessage(); err == nil {
+		if msg.MessageID != "" {
+			rec.ID = msg.MessageID
+		}
+		if t, err := time.Parse(time.RFC3339Nano, msg.Timestamp); err == nil {
+			rec.ReceivedAt = t
+		}
+	}
+	return rec
+}
+
+// addApplication adds app to the applications of the record once.
+func (r *historyRecord) addApplication(app string) {
+	for _, a := range r.Applications {
+		if a == app {
+			return
+		}
+	}
+	r.Applications = append(r.Applications, app)
+}
+
+// addDelivery records the outcome of delivering n to sink.
+func (r *historyRecord) addDelivery(n *notifyInput, sink, status string, err error) {
+	d := historyDelivery{Sink: sink, Status: status}
+	if err != nil {
+		d.Error = err.Error()
+	}
+
+	for i := range r.Notifications {
+		if r.Notifications[i].ApplicationName == n.ApplicationName {
+			r.Notifications[i].Deliveries = append(r.Notifications[i].Deliveries, d)
+			return
+		}
+	}
+	r.Notifications = append(r.Notifications, historyNotification{
+		ApplicationName: n.ApplicationName,
+		Severity:        n.Severity,
+		EventCount:      len(n.Events),
+		Deliveries:      []historyDelivery{d},
+	})
+}
+
+// result returns the status of a message handled without error.
+func (r *historyRecord) result() string {
+	switch {
+	case len(r.Notifications) > 0:
+		return historyNotified
+	case r.EventCount == 0:
+		return historyNoEvents
+	default:
+		return historySuppressed
+	}
+}
+
+// history keeps records of processed alarm messages.
+type history struct {
+	store state.Store
+}
+
+func historyKey(receivedAt time.Time, id string) string {
+	// 時刻順に並ぶように固定長の書式にする
+	return fmt.Sprintf("history/%s/%s", receivedAt.UTC().Format("2006-01-02T15:04:05.000Z"), id)
+}
+
+// Save stores rec with status and the error that caused it, if any.
+func (h *history) Save(ctx context.Context, conf *config.Config, rec *historyRecord, status string, cause error) error {
+	rec.Status = status
+	rec.Error = ""
+	if cause != nil {
+		rec.Error = cause.Error()
+	}
+	rec.ProcessedAt = time.Now()
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(h.store.Put(ctx, historyKey(rec.ReceivedAt, rec.ID), data, conf.History.GetRetention()))
+}
+
+// historyQuery selects records. Zero fields match everything.
+type historyQuery struct {
+	From, To time.Time
+	// Alarm matches the configured or the CloudWatch alarm name.
+	Alarm glob.Glob
+	// App matches any of the applications of the record.
+	App    glob.Glob
+	Status []string
+	Limit  int
+}
+
+func (q historyQuery) match(rec *historyRecord) bool {
+	if !q.From.IsZero() && rec.ReceivedAt.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !rec.ReceivedAt.Before(q.To) {
+		return false
+	}
+	if q.Alarm != nil {
+		cwName := ""
+		if rec.CloudWatchAlarm != nil {
+			cwName = rec.CloudWatchAlarm.AlarmName
+		}
+		if !q.Alarm.Match(string(rec.Alarm)) && !q.Alarm.Match(cwName) {
+			return false
+		}
+	}
+	if q.App != nil {
+		var ok bool
+		for _, app := range rec.Applications {
+			if q.App.Match(app) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(q.Status) > 0 && !containsString(q.Status, rec.Status) {
+		return false
+	}
+	return true
+}
+
+// Find returns up to q.Limit records matching q, newest first, listing only
+// the days from q.From to q.To.
+func (h *history) Find(ctx context.Context, q historyQuery) ([]historyRecord, error) {
+	// キーは受信時刻順なので、範囲外のキーは読み込まずに飛ばせる
+	var from, to string
+	if !q.From.IsZero() {
+		from = historyKey(q.From, "")
+	}
+	if !q.To.IsZero() {
+		to = historyKey(q.To, "")
+	}
+
+	records := []historyRecord{}
+	for _, prefix := range historyPrefixes(q, time.Now()) {
+		items, err := h.store.List(ctx, prefix)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		// 逆順に読めば新しい順になる
+		for i := len(items) - 1; i >= 0; i-- {
+			if to != "" && items[i].Key >= to {
+				continue
+			}
+			if items[i].Key < from {
+				break
+			}
+
+			var rec historyRecord
+			if err := json.Unmarshal(items[i].Value, &rec); err != nil {
+				log.Get().Error("broken history record", zap.String("key", items[i].Key), zap.Error(err))
+				continue
+			}
+			if !q.match(&rec) {
+				continue
+			}
+			records = append(records, rec)
+			if q.Limit > 0 && len(records) >= q.Limit {
+				return records, nil
+			}
+		}
+	}
+	return records, nil
+}
+
+// historyPrefixes returns the prefixes of the days of q, newest first. Without
+// q.From the whole history is listed at once.
+func historyPrefixes(q historyQuery, now time.Time) []string {
+	if q.From.IsZero() {
+		return []string{"history/"}
+	}
+	end := q.To
+	if end.IsZero() {
+		end = now
+	}
+
+	var prefixes []string
+	first := q.From.UTC().Truncate(24 * time.Hour)
+	for day := end.UTC().Truncate(24 * time.Hour); !day.Before(first); day = day.Add(-24 * time.Hour) {
+		prefixes = append(prefixes, "history/"+day.Format("2006-01-02"))
+	}
+	return prefixes
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/state"
+)
+
+// listingStore records the prefixes listed.
+type listingStore struct {
+	state.Store
+	prefixes []string
+}
+
+func (s *listingStore) List(ctx context.Context, prefix string) ([]state.Item, error) {
+	s.prefixes = append(s.prefixes, prefix)
+	return s.Store.List(ctx, prefix)
+}
+
+func TestHistoryFind(t *testing.T) {
+	ctx := context.Background()
+	store := &listingStore{Store: state.NewMemoryStore()}
+	h := &history{store: store}
+
+	// 10月10日から6時間ごとに5日分
+	base := time.Date(2026, 10, 10, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		rec := &historyRecord{
+			ID:         fmt.Sprintf("m%02d", i),
+			Alarm:      "a",
+			ReceivedAt: base.Add(time.Duration(i) * 6 * time.Hour),
+		}
+		if err := h.Save(ctx, &config.Config{}, rec, historyNotified, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := func(records []historyRecord) []string {
+		var ids []string
+		for _, r := range records {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name         string
+		q            historyQuery
+		want         []string
+		wantPrefixes []string
+	}{
+		{
+			name:         "range within a day",
+			q:            historyQuery{From: base.Add(30 * time.Hour), To: base.Add(42 * time.Hour)},
+			want:         []string{"m06", "m05"},
+			wantPrefixes: []string{"history/2026-10-11"},
+		},
+		{
+			name:         "range over days",
+			q:            historyQuery{From: base.Add(42 * time.Hour), To: base.Add(66 * time.Hour)},
+			want:         []string{"m10", "m09", "m08", "m07"},
+			wantPrefixes: []string{"history/2026-10-12", "history/2026-10-11"},
+		},
+		{
+			name:         "limit stops listing",
+			q:            historyQuery{From: base, To: base.Add(120 * time.Hour), Limit: 3},
+			want:         []string{"m19", "m18", "m17"},
+			wantPrefixes: []string{"history/2026-10-15", "history/2026-10-14"},
+		},
+		{
+			name:         "limit at the end of a day",
+			q:            historyQuery{From: base, To: base.Add(120 * time.Hour), Limit: 4},
+			want:         []string{"m19", "m18", "m17", "m16"},
+			wantPrefixes: []string{"history/2026-10-15", "history/2026-10-14"},
+		},
+		{
+			name:         "limit across days",
+			q:            historyQuery{From: base, To: base.Add(120 * time.Hour), Limit: 6},
+			want:         []string{"m19", "m18", "m17", "m16", "m15", "m14"},
+			wantPrefixes: []string{"history/2026-10-15", "history/2026-10-14", "history/2026-10-13"},
+		},
+		{
+			name:         "without from",
+			q:            historyQuery{To: base.Add(12 * time.Hour)},
+			want:         []string{"m01", "m00"},
+			wantPrefixes: []string{"history/"},
+		},
+		{
+			name:         "empty range",
+			q:            historyQuery{From: base.Add(10 * 24 * time.Hour), To: base.Add(11 * 24 * time.Hour)},
+			wantPrefixes: []string{"history/2026-10-21", "history/2026-10-20"},
+		},
+	}
+	for _, tt := range tests {
+		store.prefixes = nil
+		got, err := h.Find(ctx, tt.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ids(got), tt.want) {
+			t.Errorf("%s: Find() = %v, want %v", tt.name, ids(got), tt.want)
+		}
+		if !reflect.DeepEqual(store.prefixes, tt.wantPrefixes) {
+			t.Errorf("%s: listed %v, want %v", tt.name, store.prefixes, tt.wantPrefixes)
+		}
+	}
+}
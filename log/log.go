@@ -1,21 +1,23 @@
 package log
 
 import (
+	"sync/atomic"
+
 	"go.uber.org/zap"
 )
 
-var c Config
+var c atomic.Value
 
 type Config struct {
 	Debug bool
 }
 
 func SetConfig(config Config) {
-	c = config
+	c.Store(config)
 }
 
 func Get() *zap.Logger {
-	if c.Debug {
+	if config, _ := c.Load().(Config); config.Debug {
 		logger, _ := zap.NewDevelopment()
 		return logger
 	}
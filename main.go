@@ -1,17 +1,27 @@
 package main
 
 import (
+	"context"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/yuichiro-h/cwl-alert-notifier/config"
 	"github.com/yuichiro-h/cwl-alert-notifier/log"
-	"github.com/yuichiro-h/go/aws/sqsrouter"
+	"go.uber.org/zap"
 )
 
 func main() {
-	if err := config.Load(os.Getenv("CONFIG_PATH")); err != nil {
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheck(os.Args[2:]))
+	}
+
+	configPath := os.Getenv("CONFIG_PATH")
+	if err := config.Load(configPath); err != nil {
 		panic(err)
 	}
 	log.SetConfig(log.Config{
@@ -19,17 +29,120 @@ func main() {
 	})
 
 	sess := session.Must(session.NewSession())
-	r := sqsrouter.New(sess, sqsrouter.WithLogger(log.Get()))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store, err := openStore(sess, config.Get().State)
+	if err != nil {
+		panic(err)
+	}
+	defer store.Close()
 
-	for _, alarm := range config.Get().Alarms {
-		r.AddHandler(alarm.SqsURL, NewAlarmHandler(alarm))
+	svc := newServices(sess, store)
+	receivers := newAlarmReceivers(ctx, svc)
+	receivers.Reconcile(config.Get())
+
+	stop := make(chan struct{})
+	go runEvery(ctx, stop, time.Minute, "report expired silences", func(ctx context.Context, conf *config.Config) error {
+		return reportExpiredSilences(ctx, svc, conf)
+	})
+	go runEvery(ctx, stop, time.Minute, "post digests", newDigestScheduler(svc).Run)
+	go runEvery(ctx, stop, time.Minute, "flush deferred notifications", func(ctx context.Context, conf *config.Config) error {
+		return flushDeferred(ctx, svc.store, conf, time.Now())
+	})
+
+	var srv *http.Server
+	if listen := config.Get().HTTP.Listen; listen != "" {
+		srv = newHTTPServer(listen, svc)
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				panic(err)
+			}
+		}()
 	}
 
-	r.Start()
+	reloadCh := make(chan struct{}, 1)
+	if w := config.Get().Reload.WatchInterval; w != nil && *w > 0 {
+		go config.Watch(configPath, time.Duration(*w)*time.Second, stop, func() {
+			select {
+			case reloadCh <- struct{}{}:
+			default:
+			}
+		})
+	}
 
 	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt)
-	<-ch
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+L:
+	for {
+		select {
+		case sig := <-ch:
+			if sig != syscall.SIGHUP {
+				break L
+			}
+			reload(configPath, receivers)
+		case <-reloadCh:
+			reload(configPath, receivers)
+		}
+	}
+
+	close(stop)
+	shutdown(receivers, srv, cancel)
+}
+
+// shutdown stops receiving messages and the HTTP server together, and waits for
+// in-flight handlers. Once the configured timeout expires, cancel aborts the
+// remaining AWS and Slack calls so that their messages are left in the queue
+// for redelivery.
+func shutdown(receivers *alarmReceivers, srv *http.Server, cancel context.CancelFunc) {
+	timeout := 25 * time.Second
+	if t := config.Get().Shutdown.Timeout; t != nil {
+		timeout = time.Duration(*t) * time.Second
+	}
+	log.Get().Info("shutting down", zap.Duration("timeout", timeout))
+	ctx, cancelTimeout := context.WithTimeout(context.Background(), timeout)
+	defer cancelTimeout()
+
+	var wg sync.WaitGroup
+	if srv != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Get().Error("failed to stop http server", zap.Error(err))
+				srv.Close()
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		receivers.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Get().Warn("shutdown timeout exceeded, aborting in-flight handlers")
+		cancel()
+		<-done
+	}
+	wg.Wait()
+	log.Get().Info("stopped")
+}
+
+// reload loads the config again and applies it, keeping the current config
+// when the new one is invalid.
+func reload(configPath string, receivers *alarmReceivers) {
+	if err := config.Load(configPath); err != nil {
+		log.Get().Error("failed to reload config", zap.Error(err))
+		return
+	}
+	log.SetConfig(log.Config{
+		Debug: config.Get().Debug,
+	})
 
-	r.Stop()
+	receivers.Reconcile(config.Get())
+	log.Get().Info("reloaded config", zap.String("path", configPath))
 }
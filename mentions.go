@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/yuichiro-h/cwl-alert-notifier/log"
+	"go.uber.org/zap"
+)
+
+// slackUserTTL is how long Slack user ids looked up by email are cached.
+const slackUserTTL = time.Hour
+
+type slackUser struct {
+	id        string
+	fetchedAt time.Time
+}
+
+// mentionResolver formats configured mentions for Slack messages.
+type mentionResolver struct {
+	mu    sync.Mutex
+	users map[string]slackUser
+}
+
+func newMentionResolver() *mentionResolver {
+	return &mentionResolver{users: map[string]slackUser{}}
+}
+
+// Resolve returns mentions formatted for Slack without duplicates. Raw
+// mentions such as "<!subteam^ID>" are kept, "@here" style mentions become
+// special mentions, emails are looked up with token and the rest are user ids.
+func (r *mentionResolver) Resolve(ctx context.Context, token string, mentions []string) []string {
+	var resolved []string
+	seen := map[string]bool{}
+	for _, m := range mentions {
+		m = strings.TrimSpace(m)
+		switch {
+		case m == "":
+			continue
+		case strings.HasPrefix(m, "<"):
+		case m == "@here" || m == "@channel" || m == "@everyone":
+			m = "<!" + m[1:] + ">"
+		case strings.Contains(m, "@"):
+			id, err := r.lookupByEmail(ctx, token, m)
+			if err != nil {
+				// 解決できない場合はメールアドレスをそのまま表示する
+				log.Get().Warn("failed to look up slack user", zap.String("email", m), zap.Error(err))
+			} else {
+				m = "<@" + id + ">"
+			}
+		default:
+			m = "<@" + m + ">"
+		}
+
+		if !seen[m] {
+			seen[m] = true
+			resolved = append(resolved, m)
+		}
+	}
+	return resolved
+}
+
+func (r *mentionResolver) lookupByEmail(ctx context.Context, token, email string) (string, error) {
+	r.mu.Lock()
+	u, ok := r.users[email]
+	r.mu.Unlock()
+	if ok && time.Since(u.fetchedAt) < slackUserTTL {
+		return u.id, nil
+	}
+
+	user, err := slack.New(token).GetUserByEmailContext(ctx, email)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	r.users[email] = slackUser{id: user.ID, fetchedAt: time.Now()}
+	r.mu.Unlock()
+	return user.ID, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/pkg/errors"
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+)
+
+// sameTimestampSlack is how many more events than needed are fetched, to find
+// the matched event among events logged at the same millisecond.
+const sameTimestampSlack = 50
+
+// streamRecord is a matched event expanded with its log stream.
+type streamRecord struct {
+	// Message is the matched event, or the whole multiline record it is a line of.
+	Message string
+	// Key identifies the record within its log group.
+	Key string
+	// Before and After are the context lines around the record.
+	Before []string
+	After  []string
+}
+
+// expandEvent reassembles the multiline record that the matched event e is a
+// line of when mc is enabled, and adds up to before and after context lines.
+func expandEvent(ctx context.Context, cwl cloudwatchlogsiface.CloudWatchLogsAPI, logGroupName string, e *cloudwatchlogs.FilteredLogEvent, mc config.MultilineConfig, before, after int) (*streamRecord, error) {
+	var maxLines int
+	if mc.Enabled() {
+		maxLines = mc.GetMaxLines()
+	}
+	events, index, err := aroundEvent(ctx, cwl, logGroupName, e, maxLines+before, maxLines+after)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]string, len(events))
+	for i, le := range events {
+		lines[i] = aws.StringValue(le.Message)
+	}
+
+	start, end := index, index+1
+	if mc.Enabled() {
+		start, end = mc.Rule().Record(lines, index, maxLines)
+	}
+	from := start - before
+	if from < 0 {
+		from = 0
+	}
+	to := end + after
+	if to > len(lines) {
+		to = len(lines)
+	}
+
+	return &streamRecord{
+		Message: strings.Join(lines[start:end], "\n"),
+		Key:     fmt.Sprintf("%s/%d/%s", *e.LogStreamName, aws.Int64Value(events[start].Timestamp), lines[start]),
+		Before:  lines[from:start],
+		After:   lines[end:to],
+	}, nil
+}
+
+// aroundEvent returns up to before and after events of the log stream of e
+// around it, and the index of e in them.
+func aroundEvent(ctx context.Context, cwl cloudwatchlogsiface.CloudWatchLogsAPI, logGroupName string, e *cloudwatchlogs.FilteredLogEvent, before, after int) ([]*cloudwatchlogs.OutputLogEvent, int, error) {
+	isMatched := func(le *cloudwatchlogs.OutputLogEvent) bool {
+		return aws.Int64Value(le.Timestamp) == *e.Timestamp && aws.StringValue(le.Message) == *e.Message
+	}
+
+	// EndTimeは含まれないため1ミリ秒先まで取得する
+	backward, err := cwl.GetLogEventsWithContext(ctx, &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(logGroupName),
+		LogStreamName: e.LogStreamName,
+		EndTime:       aws.Int64(*e.Timestamp + 1),
+		StartFromHead: aws.Bool(false),
+		Limit:         aws.Int64(int64(before + sameTimestampSlack)),
+	})
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	var events []*cloudwatchlogs.OutputLogEvent
+	for i, le := range backward.Events {
+		if isMatched(le) {
+			from := i - before
+			if from < 0 {
+				from = 0
+			}
+			events = backward.Events[from : i+1]
+			break
+		}
+	}
+	if events == nil {
+		return nil, 0, errors.Errorf("matched event not found in log stream %s", *e.LogStreamName)
+	}
+	index := len(events) - 1
+
+	if after > 0 {
+		forward, err := cwl.GetLogEventsWithContext(ctx, &cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  aws.String(logGroupName),
+			LogStreamName: e.LogStreamName,
+			StartTime:     e.Timestamp,
+			StartFromHead: aws.Bool(true),
+			Limit:         aws.Int64(int64(after + sameTimestampSlack)),
+		})
+		if err != nil {
+			return nil, 0, errors.WithStack(err)
+		}
+		for i, le := range forward.Events {
+			if isMatched(le) {
+				to := i + 1 + after
+				if to > len(forward.Events) {
+					to = len(forward.Events)
+				}
+				events = append(events, forward.Events[i+1:to]...)
+				break
+			}
+		}
+	}
+
+	return events, index, nil
+}
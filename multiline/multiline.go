@@ -0,0 +1,48 @@
+package multiline
+
+import (
+	"regexp"
+)
+
+// Rule tells which lines of a log stream belong to one record, such as a stack
+// trace logged one line per event.
+type Rule struct {
+	// Start matches lines that always begin a record. It may be nil.
+	Start *regexp.Regexp
+	// Continuation matches lines that belong to the line before them.
+	Continuation *regexp.Regexp
+}
+
+// Presets returns the built-in rules by name.
+func Presets() map[string]Rule {
+	return map[string]Rule{
+		"java": {
+			Continuation: regexp.MustCompile(`^(\s+at |\s+\.\.\. \d+ (more|common frames omitted)|\s*Caused by:|\s*Suppressed:)`),
+		},
+		"python": {
+			Start:        regexp.MustCompile(`^Traceback \(most recent call last\):`),
+			Continuation: regexp.MustCompile(`^(\s|[A-Za-z_][\w.]*(Error|Exception|Warning|Exit|Interrupt)\b|During handling of the above exception|The above exception was the direct cause)`),
+		},
+	}
+}
+
+func (r Rule) continues(line string) bool {
+	if r.Start != nil && r.Start.MatchString(line) {
+		return false
+	}
+	return r.Continuation.MatchString(line)
+}
+
+// Record returns the range [start, end) of the record that contains the line
+// at index, keeping at most maxLines lines from its beginning.
+func (r Rule) Record(lines []string, index, maxLines int) (int, int) {
+	start := index
+	for start > 0 && index-start < maxLines-1 && r.continues(lines[start]) {
+		start--
+	}
+	end := index + 1
+	for end < len(lines) && end-start < maxLines && r.continues(lines[end]) {
+		end++
+	}
+	return start, end
+}
@@ -0,0 +1,70 @@
+package multiline
+
+import (
+	"regexp"
+	"testing"
+)
+
+var javaTrace = []string{
+	"2026-10-18 10:00:00.000 INFO  [main] c.e.App - started",
+	`Exception in thread "main" java.lang.IllegalStateException: order 42 not found`,
+	"\tat com.example.OrderService.find(OrderService.java:57)",
+	"\tat com.example.App.main(App.java:21)",
+	"Caused by: java.sql.SQLException: connection refused",
+	"\tat com.example.db.Pool.get(Pool.java:103)",
+	"\t... 2 more",
+	"2026-10-18 10:00:01.000 INFO  [main] c.e.App - stopped",
+}
+
+var pythonTrace = []string{
+	"Traceback (most recent call last):",
+	`  File "/app/main.py", line 10, in <module>`,
+	"    main()",
+	`  File "/app/main.py", line 6, in main`,
+	`    raise ValueError("invalid id")`,
+	"ValueError: invalid id",
+	"Traceback (most recent call last):",
+	`  File "/app/worker.py", line 3, in <module>`,
+	"KeyError: 'id'",
+	"INFO:root:done",
+}
+
+func TestRuleRecord(t *testing.T) {
+	presets := Presets()
+	custom := Rule{
+		Start:        regexp.MustCompile(`^\d{4}-\d{2}-\d{2} `),
+		Continuation: regexp.MustCompile(`.`),
+	}
+
+	tests := []struct {
+		name      string
+		rule      Rule
+		lines     []string
+		index     int
+		maxLines  int
+		wantStart int
+		wantEnd   int
+	}{
+		{"java first line", presets["java"], javaTrace, 1, 200, 1, 7},
+		{"java frame", presets["java"], javaTrace, 2, 200, 1, 7},
+		{"java caused by", presets["java"], javaTrace, 4, 200, 1, 7},
+		{"java more", presets["java"], javaTrace, 6, 200, 1, 7},
+		{"java other line", presets["java"], javaTrace, 0, 200, 0, 1},
+		{"java max lines from the beginning", presets["java"], javaTrace, 1, 3, 1, 4},
+		{"java max lines around the line", presets["java"], javaTrace, 5, 3, 3, 6},
+		{"java single line", presets["java"], javaTrace, 4, 1, 4, 5},
+		{"python error line", presets["python"], pythonTrace, 5, 200, 0, 6},
+		{"python traceback line", presets["python"], pythonTrace, 0, 200, 0, 6},
+		{"python next traceback", presets["python"], pythonTrace, 8, 200, 6, 9},
+		{"python other line", presets["python"], pythonTrace, 9, 200, 9, 10},
+		{"python max lines", presets["python"], pythonTrace, 2, 3, 0, 3},
+		{"custom start", custom, javaTrace, 3, 200, 0, 7},
+	}
+	for _, tt := range tests {
+		start, end := tt.rule.Record(tt.lines, tt.index, tt.maxLines)
+		if start != tt.wantStart || end != tt.wantEnd {
+			t.Errorf("%s: Record(%d, %d) = [%d, %d), want [%d, %d)",
+				tt.name, tt.index, tt.maxLines, start, end, tt.wantStart, tt.wantEnd)
+		}
+	}
+}
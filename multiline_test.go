@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+)
+
+func TestExpandEvent(t *testing.T) {
+	java := []string{
+		"2026-10-18 10:00:00.000 INFO  [main] c.e.App - started",
+		`Exception in thread "main" java.lang.IllegalStateException: order 42 not found`,
+		"\tat com.example.OrderService.find(OrderService.java:57)",
+		"\tat com.example.App.main(App.java:21)",
+		"Caused by: java.sql.SQLException: connection refused",
+		"\tat com.example.db.Pool.get(Pool.java:103)",
+		"\t... 2 more",
+		"2026-10-18 10:00:00.000 INFO  [main] c.e.App - stopped",
+		"2026-10-18 10:00:01.000 INFO  [main] c.e.App - exited",
+	}
+	python := []string{
+		"INFO:root:started",
+		"Traceback (most recent call last):",
+		`  File "/app/main.py", line 10, in <module>`,
+		"    main()",
+		`  File "/app/main.py", line 6, in main`,
+		`    raise ValueError("invalid id")`,
+		"ValueError: invalid id",
+		"INFO:root:done",
+	}
+	// ほぼ全ての行が同じミリ秒に出力されている
+	cwl := &fakeCWL{
+		streams: map[string][]string{"java": java, "python": python},
+		times: map[string][]int64{
+			"java":   {99, 100, 100, 100, 100, 100, 100, 100, 101},
+			"python": {100, 100, 100, 100, 100, 100, 100, 100},
+		},
+	}
+	three := 3
+
+	tests := []struct {
+		name          string
+		stream        string
+		index         int
+		mc            config.MultilineConfig
+		before, after int
+		want          streamRecord
+	}{
+		{
+			name:   "java trace matched at caused by",
+			stream: "java",
+			index:  4,
+			mc:     config.MultilineConfig{Preset: "java"},
+			before: 1,
+			after:  1,
+			want: streamRecord{
+				Message: strings.Join(java[1:7], "\n"),
+				Key:     "java/100/" + java[1],
+				Before:  java[0:1],
+				After:   java[7:8],
+			},
+		},
+		{
+			name:   "java trace longer than max_lines",
+			stream: "java",
+			index:  1,
+			mc:     config.MultilineConfig{Preset: "java", MaxLines: &three},
+			after:  1,
+			want: streamRecord{
+				Message: strings.Join(java[1:4], "\n"),
+				Key:     "java/100/" + java[1],
+				Before:  []string{},
+				After:   java[4:5],
+			},
+		},
+		{
+			name:   "python trace matched at the error",
+			stream: "python",
+			index:  6,
+			mc:     config.MultilineConfig{Preset: "python"},
+			before: 2,
+			after:  2,
+			want: streamRecord{
+				Message: strings.Join(python[1:7], "\n"),
+				Key:     "python/100/" + python[1],
+				Before:  python[0:1],
+				After:   python[7:8],
+			},
+		},
+		{
+			name:   "context only",
+			stream: "java",
+			index:  8,
+			before: 2,
+			after:  1,
+			want: streamRecord{
+				Message: java[8],
+				Key:     "java/101/" + java[8],
+				Before:  java[6:8],
+				After:   []string{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		e := cwl.matched(tt.stream, tt.index)[0]
+		got, err := expandEvent(context.Background(), cwl, "group", e, tt.mc, tt.before, tt.after)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%s: expandEvent() = %#v, want %#v", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func TestAroundEventSameTimestamp(t *testing.T) {
+	// 全てのイベントが同じミリ秒に出力されていると、前後の取得は
+	// sameTimestampSlack 件の余裕の範囲でしか一致したイベントを見つけられない
+	stream := make([]string, sameTimestampSlack+5)
+	times := make([]int64, len(stream))
+	for i := range stream {
+		stream[i] = fmt.Sprintf("line %d", i)
+		times[i] = 100
+	}
+	cwl := &fakeCWL{streams: map[string][]string{"s": stream}, times: map[string][]int64{"s": times}}
+
+	tests := []struct {
+		index     int
+		want      []string
+		wantIndex int
+		wantErr   bool
+	}{
+		{index: 10, want: stream[7:13], wantIndex: 3},
+		// 後方の取得に含まれない
+		{index: 1, wantErr: true},
+		// 前方の取得に含まれないので後のコンテキストがない
+		{index: sameTimestampSlack + 3, want: stream[sameTimestampSlack : sameTimestampSlack+4], wantIndex: 3},
+	}
+	for _, tt := range tests {
+		e := cwl.matched("s", tt.index)[0]
+		events, index, err := aroundEvent(context.Background(), cwl, "group", e, 3, 2)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("aroundEvent(%d) succeeded, want an error", tt.index)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("aroundEvent(%d): %v", tt.index, err)
+			continue
+		}
+		var got []string
+		for _, le := range events {
+			got = append(got, *le.Message)
+		}
+		if !reflect.DeepEqual(got, tt.want) || index != tt.wantIndex {
+			t.Errorf("aroundEvent(%d) = %q, %d, want %q, %d", tt.index, got, index, tt.want, tt.wantIndex)
+		}
+	}
+}
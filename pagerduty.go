@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+)
+
+// pagerDutyEvent is an event of the PagerDuty Events API v2.
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key,omitempty"`
+	Payload     pagerDutyPayload `json:"payload"`
+	Links       []pagerDutyLink  `json:"links,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Timestamp     string                 `json:"timestamp,omitempty"`
+	Component     string                 `json:"component,omitempty"`
+	Group         string                 `json:"group,omitempty"`
+	CustomDetails map[string]interface{} `json:"custom_details,omitempty"`
+}
+
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+// pagerDutySummaryLength is the longest summary PagerDuty accepts.
+const pagerDutySummaryLength = 1024
+
+// triggerPagerDuty triggers a PagerDuty incident for in. dedupKey makes retries
+// of the same notification update one incident.
+func triggerPagerDuty(ctx context.Context, pd config.PagerDutyConfig, dedupKey string, in *notifyInput) error {
+	var messages []string
+	for _, e := range in.Events {
+		messages = append(messages, truncate(e.Message, digestSampleLength))
+	}
+	var alarmName string
+	if in.Alarm != nil {
+		alarmName = in.Alarm.AlarmName
+	}
+
+	ev := pagerDutyEvent{
+		RoutingKey:  pd.RoutingKey,
+		EventAction: "trigger",
+		DedupKey:    dedupKey,
+		Payload: pagerDutyPayload{
+			Summary:   truncate(fmt.Sprintf("%d log event(s) found in %s by %s", len(in.Events), in.ApplicationName, alarmName), pagerDutySummaryLength-1),
+			Source:    in.LogGroupName,
+			Severity:  in.Severity,
+			Timestamp: in.Events[0].Timestamp.UTC().Format(time.RFC3339),
+			Component: in.ApplicationName,
+			Group:     alarmName,
+			CustomDetails: map[string]interface{}{
+				"events": messages,
+			},
+		},
+		Links: []pagerDutyLink{
+			{Href: in.FirstLogURL, Text: "Open Head Log"},
+			{Href: in.ConsoleURL, Text: "View all logs"},
+		},
+	}
+
+	data, err := json.Marshal(&ev)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, pd.GetURL(), bytes.NewReader(data))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return errors.Errorf("pagerduty responded %s: %s", res.Status, body)
+	}
+	return nil
+}
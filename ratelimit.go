@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+)
+
+// rateLimiter is a token bucket per destination.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// Allow takes a token from the bucket of sink, refilled at perMinute tokens per
+// minute up to burst, and reports whether one was available.
+func (l *rateLimiter) Allow(sink string, perMinute float64, burst int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[sink]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[sink] = b
+	}
+
+	b.tokens += now.Sub(b.last).Minutes() * perMinute
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// nextRateLimitFlush returns when notifications rate limited at now are posted.
+func nextRateLimitFlush(conf *config.Config, now time.Time) time.Time {
+	interval := time.Duration(conf.RateLimit.GetInterval()) * time.Second
+	return now.Truncate(interval).Add(interval)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/render"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := newRateLimiter()
+	t0 := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		sink string
+		at   time.Duration
+		want bool
+	}{
+		// burst の分だけ続けて通す
+		{"slack:#a", 0, true},
+		{"slack:#a", 0, true},
+		{"slack:#a", 0, true},
+		{"slack:#a", 0, false},
+		{"slack:#b", 0, true},
+		// 毎分 2 トークン補充される
+		{"slack:#a", 20 * time.Second, false},
+		{"slack:#a", 31 * time.Second, true},
+		{"slack:#a", 31 * time.Second, false},
+		// 補充は burst までで止まる
+		{"slack:#a", 10 * time.Minute, true},
+		{"slack:#a", 10 * time.Minute, true},
+		{"slack:#a", 10 * time.Minute, true},
+		{"slack:#a", 10 * time.Minute, false},
+	}
+	for i, tt := range tests {
+		if got := l.Allow(tt.sink, 2, 3, t0.Add(tt.at)); got != tt.want {
+			t.Errorf("#%d: Allow(%s) at +%s = %v, want %v", i, tt.sink, tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestNextRateLimitFlush(t *testing.T) {
+	minute := int64(60)
+	tests := []struct {
+		interval *int64
+		now      time.Time
+		want     time.Time
+	}{
+		{nil, time.Date(2026, 10, 18, 9, 2, 10, 0, time.UTC), time.Date(2026, 10, 18, 9, 5, 0, 0, time.UTC)},
+		{nil, time.Date(2026, 10, 18, 9, 5, 0, 0, time.UTC), time.Date(2026, 10, 18, 9, 10, 0, 0, time.UTC)},
+		{nil, time.Date(2026, 10, 18, 23, 59, 59, 0, time.UTC), time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{&minute, time.Date(2026, 10, 18, 9, 2, 10, 0, time.UTC), time.Date(2026, 10, 18, 9, 3, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		conf := &config.Config{RateLimit: config.RateLimitConfig{Interval: tt.interval}}
+		if got := nextRateLimitFlush(conf, tt.now); !got.Equal(tt.want) {
+			t.Errorf("nextRateLimitFlush(%s) = %s, want %s", tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestDeliverSlackRateLimited(t *testing.T) {
+	api, restore := newFakeSlack(t)
+	defer restore()
+
+	ctx := context.Background()
+	perMinute, burst := 1.0, 2
+	conf := &config.Config{
+		Slack:     config.SlackConfig{ApiToken: "xoxb-global"},
+		RateLimit: config.RateLimitConfig{PerMinute: &perMinute, Burst: &burst},
+	}
+	svc := newTestServices()
+	svc.deliveries = &deliveries{store: svc.store}
+	svc.limiter = newRateLimiter()
+	h := &AlarmHandler{name: "alarm", svc: svc}
+	now := time.Date(2026, 10, 18, 9, 2, 10, 0, time.UTC)
+
+	var statuses []string
+	for _, id := range []string{"m1", "m2", "m3"} {
+		n := &notifyInput{
+			GroupIndex:      -1,
+			ApplicationName: "app",
+			Slack:           config.SlackConfig{ApiToken: "xoxb-global", Channel: "#a"},
+			Events:          []render.Event{{Message: "error", Body: "error"}},
+		}
+		status, err := h.deliverSlack(ctx, conf, id, n, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, status)
+	}
+	if want := []string{deliverySent, deliverySent, deliveryDeferred}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+	if n := len(api.Posts()); n != 2 {
+		t.Errorf("posted %d messages, want 2", n)
+	}
+
+	// 集約時刻になるまでは送らない
+	if err := flushDeferred(ctx, svc.store, conf, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(api.Posts()); n != 2 {
+		t.Errorf("posted %d messages before the flush, want 2", n)
+	}
+	if err := flushDeferred(ctx, svc.store, conf, nextRateLimitFlush(conf, now)); err != nil {
+		t.Fatal(err)
+	}
+	posts := api.Posts()
+	if len(posts) != 3 || posts[2].Get("text") != "1 alert(s) in the last 5 minute(s)" {
+		t.Errorf("posts = %v, want the rate limited notification aggregated", posts)
+	}
+}
+
+func TestFlushDeferredBatches(t *testing.T) {
+	api, restore := newFakeSlack(t)
+	defer restore()
+
+	ctx := context.Background()
+	svc := newTestServices()
+	conf := &config.Config{
+		Slack: config.SlackConfig{ApiToken: "xoxb-global"},
+		Alarms: map[config.AlarmName]config.Alarm{
+			"x": {Groups: []config.AlarmGroup{{Slack: config.SlackConfig{ApiToken: "xoxb-x"}}}},
+			"y": {Groups: []config.AlarmGroup{{Slack: config.SlackConfig{ApiToken: "xoxb-y"}}}},
+		},
+	}
+	now := time.Date(2026, 10, 18, 9, 5, 0, 0, time.UTC)
+	due := now.Add(-5 * time.Minute)
+
+	add := func(id, reason string, alarm config.AlarmName, channel, app string, events int, flushAt time.Time) {
+		t.Helper()
+		in := &notifyInput{
+			AlarmName:       alarm,
+			ApplicationName: app,
+			Slack:           config.SlackConfig{ApiToken: "stale", Channel: channel},
+			FirstLogURL:     "https://log/" + app,
+			Events:          make([]render.Event, events),
+		}
+		if err := addDeferred(ctx, svc.store, id, reason, flushAt, in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("1", deferredByRateLimit, "x", "#a", "app1", 1, due)
+	add("2", deferredByRateLimit, "x", "#a", "app2", 3, due)
+	add("3", deferredByRateLimit, "y", "#a", "app3", 1, due)
+	add("4", deferredByRateLimit, "x", "#b", "app4", 1, due)
+	add("5", deferredByWindow, "x", "#a", "app5", 4, due)
+	add("6", deferredByWindow, "x", "#a", "app6", 2, due)
+	add("7", deferredByRateLimit, "x", "#a", "app7", 1, now.Add(time.Minute))
+
+	if err := flushDeferred(ctx, svc.store, conf, now); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, p := range api.Posts() {
+		got = append(got, strings.Join([]string{p.Get("token"), p.Get("channel"), p.Get("text")}, " "))
+	}
+	sort.Strings(got)
+	want := []string{
+		"xoxb-x #a 2 alert(s) in the last 5 minute(s)",
+		"xoxb-x #a 6 log event(s) were found during the quiet window",
+		"xoxb-x #b 1 alert(s) in the last 5 minute(s)",
+		"xoxb-y #a 1 alert(s) in the last 5 minute(s)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("posts = %q, want %q", got, want)
+	}
+
+	items, err := svc.store.List(ctx, "deferred/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || !strings.HasSuffix(items[0].Key, "/7") {
+		t.Errorf("left %v, want only the notification not due", items)
+	}
+}
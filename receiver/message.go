@@ -0,0 +1,55 @@
+package receiver
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+)
+
+// Message is a received SQS message passed to a Handler.
+type Message struct {
+	*sqs.Message
+	deleteMessage bool
+	// stopHeartbeat stops extending the visibility timeout.
+	stopHeartbeat func()
+}
+
+type SNSMessage struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	TopicArn         string `json:"TopicArn"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SignatureCertURL string `json:"SignatureCertURL"`
+	UnsubscribeURL   string `json:"UnsubscribeURL"`
+}
+
+// GetSNSMessage decodes the body of a message delivered by an SNS subscription.
+func (m *Message) GetSNSMessage() (*SNSMessage, error) {
+	var msg SNSMessage
+	if err := json.Unmarshal([]byte(*m.Body), &msg); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &msg, nil
+}
+
+// ReceiveCount returns how many times the message has been received, including
+// this time. It is 0 when SQS did not report it.
+func (m *Message) ReceiveCount() int {
+	v, ok := m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]
+	if !ok || v == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(*v)
+	return n
+}
+
+// SetDeleteOnFinish sets whether the message is deleted from the queue after
+// the handler returns.
+func (m *Message) SetDeleteOnFinish(delete bool) {
+	m.deleteMessage = delete
+}
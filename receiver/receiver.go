@@ -0,0 +1,270 @@
+package receiver
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"go.uber.org/zap"
+)
+
+// Handler handles a single message. ctx is canceled when in-flight work must
+// be aborted.
+type Handler func(ctx context.Context, msg *Message)
+
+type Options struct {
+	// Concurrency is the number of messages handled at the same time.
+	Concurrency int
+	// MaxMessages is the number of messages requested per receive (1-10).
+	MaxMessages int64
+	// WaitTimeSeconds enables long polling when greater than zero.
+	WaitTimeSeconds int64
+	// Ordered handles messages of the same message group one at a time in
+	// receive order. Messages without a group, as in standard queues, all
+	// belong to the same group.
+	Ordered bool
+	// VisibilityTimeout, when greater than zero, is the visibility timeout in
+	// seconds requested on receive and set again every half of it until a
+	// message is handled, so that a slow handler, or one handling an earlier
+	// message of the same group, does not let the message be redelivered.
+	VisibilityTimeout int64
+}
+
+// retryInterval is how long the receiver waits after a failed receive.
+const retryInterval = 5 * time.Second
+
+// Receiver receives messages from a queue and dispatches them to a pool of workers.
+type Receiver struct {
+	client   sqsiface.SQSAPI
+	queueURL string
+	handler  Handler
+	opts     Options
+	logger   *zap.Logger
+
+	// heartbeatInterval is how often the visibility timeout is extended.
+	heartbeatInterval time.Duration
+
+	slots      chan struct{}
+	queues     []chan *Message
+	stopPoll   context.CancelFunc
+	pollDone   chan struct{}
+	workerWait sync.WaitGroup
+}
+
+func New(client sqsiface.SQSAPI, queueURL string, h Handler, opts Options, logger *zap.Logger) *Receiver {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.MaxMessages < 1 {
+		opts.MaxMessages = 1
+	}
+
+	return &Receiver{
+		client:   client,
+		queueURL: queueURL,
+		handler:  h,
+		opts:     opts,
+		logger:   logger.With(zap.String("sqs_url", queueURL)),
+
+		heartbeatInterval: time.Duration(opts.VisibilityTimeout) * time.Second / 2,
+	}
+}
+
+// Start starts receiving. ctx is passed to the handler of every message.
+func (r *Receiver) Start(ctx context.Context) {
+	r.slots = make(chan struct{}, r.opts.Concurrency)
+
+	// 順序保証が必要な場合はメッセージグループごとに同じワーカーへ振り分ける
+	queueCount := 1
+	if r.opts.Ordered {
+		queueCount = r.opts.Concurrency
+	}
+	r.queues = make([]chan *Message, queueCount)
+	for i := range r.queues {
+		r.queues[i] = make(chan *Message, r.opts.Concurrency)
+	}
+
+	for i := 0; i < r.opts.Concurrency; i++ {
+		r.workerWait.Add(1)
+		go r.work(ctx, r.queues[i%queueCount])
+	}
+
+	pollCtx, cancel := context.WithCancel(context.Background())
+	r.stopPoll = cancel
+	r.pollDone = make(chan struct{})
+	go r.poll(pollCtx, ctx)
+}
+
+// Stop stops receiving and returns after every received message has been handled.
+func (r *Receiver) Stop() {
+	r.stopPoll()
+	<-r.pollDone
+
+	for _, q := range r.queues {
+		close(q)
+	}
+	r.workerWait.Wait()
+}
+
+// poll receives until ctx is canceled. handlerCtx is the context of the
+// handlers, which outlives ctx while Stop drains received messages.
+func (r *Receiver) poll(ctx, handlerCtx context.Context) {
+	defer close(r.pollDone)
+
+	for {
+		// 処理中のメッセージで埋まっている間は受信しない
+		select {
+		case r.slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		n := int64(1)
+		for n < r.opts.MaxMessages && r.tryAcquire() {
+			n++
+		}
+
+		in := &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(r.queueURL),
+			MaxNumberOfMessages:   aws.Int64(n),
+			WaitTimeSeconds:       aws.Int64(r.opts.WaitTimeSeconds),
+			AttributeNames:        []*string{aws.String(sqs.QueueAttributeNameAll)},
+			MessageAttributeNames: []*string{aws.String(sqs.QueueAttributeNameAll)},
+		}
+		if r.opts.VisibilityTimeout > 0 {
+			// 最初の延長までキューの設定値ではなくこちらの値で不可視にしておく
+			in.VisibilityTimeout = aws.Int64(r.opts.VisibilityTimeout)
+		}
+		res, err := r.client.ReceiveMessageWithContext(ctx, in)
+		if err != nil {
+			r.release(n)
+			if ctx.Err() != nil {
+				return
+			}
+			r.logReceiveError(err)
+
+			select {
+			case <-time.After(retryInterval):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		r.release(n - int64(len(res.Messages)))
+		for _, msg := range res.Messages {
+			r.dispatch(handlerCtx, &Message{Message: msg})
+		}
+	}
+}
+
+func (r *Receiver) tryAcquire() bool {
+	select {
+	case r.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *Receiver) release(n int64) {
+	for i := int64(0); i < n; i++ {
+		<-r.slots
+	}
+}
+
+func (r *Receiver) dispatch(ctx context.Context, msg *Message) {
+	if r.opts.VisibilityTimeout > 0 {
+		// 同じグループの前のメッセージを待つ間に再配信されないよう、受け付けた時点から延長する
+		done := make(chan struct{})
+		msg.stopHeartbeat = func() { close(done) }
+		go r.heartbeat(ctx, msg, done)
+	}
+
+	if len(r.queues) == 1 {
+		r.queues[0] <- msg
+		return
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(aws.StringValue(msg.Attributes[sqs.MessageSystemAttributeNameMessageGroupId])))
+	r.queues[h.Sum32()%uint32(len(r.queues))] <- msg
+}
+
+func (r *Receiver) work(ctx context.Context, queue <-chan *Message) {
+	defer r.workerWait.Done()
+
+	for msg := range queue {
+		r.handle(ctx, msg)
+		r.release(1)
+	}
+}
+
+func (r *Receiver) handle(ctx context.Context, msg *Message) {
+	if msg.stopHeartbeat != nil {
+		defer msg.stopHeartbeat()
+	}
+
+	r.handler(ctx, msg)
+
+	if !msg.deleteMessage {
+		return
+	}
+	_, err := r.client.DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(r.queueURL),
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	if err != nil {
+		r.logger.Error(err.Error(), zap.String("message_id", aws.StringValue(msg.MessageId)))
+	}
+}
+
+func (r *Receiver) logReceiveError(err error) {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		r.logger.Error(err.Error())
+		return
+	}
+
+	switch {
+	case awsErr == credentials.ErrNoValidProvidersFoundInChain:
+		r.logger.Warn(awsErr.Message())
+	case awsErr.Code() == sqs.ErrCodeQueueDoesNotExist:
+		r.logger.Error("not found queue")
+	default:
+		r.logger.Error(awsErr.Message(), zap.Any("error", awsErr))
+	}
+}
+
+// heartbeat extends the visibility timeout of msg until done is closed or ctx
+// is canceled. It runs from when msg is dispatched, including while it waits
+// for a worker, and keeps extending while Stop drains in-flight messages.
+func (r *Receiver) heartbeat(ctx context.Context, msg *Message, done <-chan struct{}) {
+	ticker := time.NewTicker(r.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := r.client.ChangeMessageVisibilityWithContext(ctx, &sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          aws.String(r.queueURL),
+				ReceiptHandle:     msg.ReceiptHandle,
+				VisibilityTimeout: aws.Int64(r.opts.VisibilityTimeout),
+			})
+			if err != nil && ctx.Err() == nil {
+				r.logger.Warn("failed to extend visibility timeout",
+					zap.String("message_id", aws.StringValue(msg.MessageId)),
+					zap.Error(err))
+			}
+		}
+	}
+}
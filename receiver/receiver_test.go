@@ -0,0 +1,300 @@
+package receiver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"go.uber.org/zap"
+)
+
+// fakeSQS returns the scripted batches in order, at most as many messages as
+// requested at a time, and blocks once they run out.
+type fakeSQS struct {
+	sqsiface.SQSAPI
+
+	mu        sync.Mutex
+	batches   [][]*sqs.Message
+	requested []int64
+	inputs    []*sqs.ReceiveMessageInput
+	deleted   []string
+	extended  map[string]int
+}
+
+func (f *fakeSQS) ReceiveMessageWithContext(ctx aws.Context, in *sqs.ReceiveMessageInput, _ ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	f.mu.Lock()
+	f.requested = append(f.requested, aws.Int64Value(in.MaxNumberOfMessages))
+	f.inputs = append(f.inputs, in)
+	if len(f.batches) == 0 {
+		f.mu.Unlock()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	batch := f.batches[0]
+	if n := int(aws.Int64Value(in.MaxNumberOfMessages)); len(batch) > n {
+		f.batches[0] = batch[n:]
+		batch = batch[:n]
+	} else {
+		f.batches = f.batches[1:]
+	}
+	f.mu.Unlock()
+
+	return &sqs.ReceiveMessageOutput{Messages: batch}, nil
+}
+
+func (f *fakeSQS) DeleteMessage(in *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted = append(f.deleted, aws.StringValue(in.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibilityWithContext(_ aws.Context, in *sqs.ChangeMessageVisibilityInput, _ ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if aws.Int64Value(in.VisibilityTimeout) != 60 {
+		return nil, fmt.Errorf("visibility timeout = %d, want 60", aws.Int64Value(in.VisibilityTimeout))
+	}
+	if f.extended == nil {
+		f.extended = map[string]int{}
+	}
+	f.extended[aws.StringValue(in.ReceiptHandle)]++
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (f *fakeSQS) extensions(handle string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.extended[handle]
+}
+
+func (f *fakeSQS) receives() []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int64{}, f.requested...)
+}
+
+func newMessage(id, group string) *sqs.Message {
+	m := &sqs.Message{
+		MessageId:     aws.String(id),
+		ReceiptHandle: aws.String(id),
+		Body:          aws.String("{}"),
+	}
+	if group != "" {
+		m.Attributes = map[string]*string{sqs.MessageSystemAttributeNameMessageGroupId: aws.String(group)}
+	}
+	return m
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReceiverSlots(t *testing.T) {
+	client := &fakeSQS{batches: [][]*sqs.Message{
+		{newMessage("1", ""), newMessage("2", "")},
+		{newMessage("3", "")},
+		{newMessage("4", "")},
+	}}
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var active, maxActive int
+	handler := func(ctx context.Context, msg *Message) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		msg.SetDeleteOnFinish(true)
+	}
+
+	r := New(client, "https://sqs/q", handler, Options{Concurrency: 3, MaxMessages: 10, VisibilityTimeout: 60}, zap.NewNop())
+	r.Start(context.Background())
+
+	// 3件処理中の間は受信しない
+	waitFor(t, "3 messages in flight", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return active == 3
+	})
+	time.Sleep(20 * time.Millisecond)
+	if got := client.receives(); len(got) != 2 || got[0] != 3 || got[1] != 1 {
+		t.Fatalf("receives while full = %v, want [3 1]", got)
+	}
+
+	// 1件終われば1件分だけ受信する
+	release <- struct{}{}
+	waitFor(t, "receive after a slot is freed", func() bool { return len(client.receives()) == 3 })
+	if got := client.receives()[2]; got != 1 {
+		t.Errorf("receive after a slot is freed requested %d, want 1", got)
+	}
+
+	close(release)
+	waitFor(t, "receive with all slots free", func() bool { return len(client.receives()) >= 4 })
+	r.Stop()
+
+	if maxActive != 3 {
+		t.Errorf("max concurrent handlers = %d, want 3", maxActive)
+	}
+	if len(client.deleted) != 4 {
+		t.Errorf("deleted %v, want 4 messages", client.deleted)
+	}
+	for _, in := range client.inputs {
+		if aws.Int64Value(in.VisibilityTimeout) != 60 {
+			t.Errorf("receive visibility timeout = %v, want 60", aws.Int64Value(in.VisibilityTimeout))
+		}
+	}
+}
+
+func TestReceiverOrdered(t *testing.T) {
+	groups := []string{"a", "b", "c"}
+	var batches [][]*sqs.Message
+	for i := 0; i < 5; i++ {
+		var batch []*sqs.Message
+		for _, g := range groups {
+			batch = append(batch, newMessage(fmt.Sprintf("%s%d", g, i), g))
+		}
+		batches = append(batches, batch)
+	}
+	client := &fakeSQS{batches: batches}
+
+	var mu sync.Mutex
+	handled := map[string][]string{}
+	active := map[string]int{}
+	var overlapped bool
+	var count int
+	handler := func(ctx context.Context, msg *Message) {
+		g := aws.StringValue(msg.Attributes[sqs.MessageSystemAttributeNameMessageGroupId])
+		mu.Lock()
+		active[g]++
+		if active[g] > 1 {
+			overlapped = true
+		}
+		mu.Unlock()
+
+		time.Sleep(2 * time.Millisecond)
+
+		mu.Lock()
+		active[g]--
+		handled[g] = append(handled[g], aws.StringValue(msg.MessageId))
+		count++
+		mu.Unlock()
+	}
+
+	r := New(client, "https://sqs/q.fifo", handler, Options{Concurrency: 4, MaxMessages: 10, Ordered: true}, zap.NewNop())
+	r.Start(context.Background())
+	waitFor(t, "all messages handled", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return count == 15
+	})
+	r.Stop()
+
+	if overlapped {
+		t.Error("messages of the same group were handled concurrently")
+	}
+	for _, g := range groups {
+		want := []string{g + "0", g + "1", g + "2", g + "3", g + "4"}
+		if fmt.Sprint(handled[g]) != fmt.Sprint(want) {
+			t.Errorf("group %s handled in %v, want %v", g, handled[g], want)
+		}
+	}
+	if len(client.deleted) != 0 {
+		t.Errorf("deleted %v without SetDeleteOnFinish", client.deleted)
+	}
+}
+
+func TestReceiverStopWaitsForInFlight(t *testing.T) {
+	client := &fakeSQS{batches: [][]*sqs.Message{{newMessage("1", "")}}}
+
+	started := make(chan struct{})
+	var finished bool
+	handler := func(ctx context.Context, msg *Message) {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		finished = true
+		msg.SetDeleteOnFinish(true)
+	}
+
+	r := New(client, "https://sqs/q", handler, Options{Concurrency: 2, MaxMessages: 2}, zap.NewNop())
+	r.Start(context.Background())
+	<-started
+	r.Stop()
+
+	if !finished {
+		t.Error("Stop returned before the in-flight message was handled")
+	}
+	if len(client.deleted) != 1 {
+		t.Errorf("deleted %v, want the handled message", client.deleted)
+	}
+}
+
+func TestReceiverHeartbeat(t *testing.T) {
+	client := &fakeSQS{batches: [][]*sqs.Message{{newMessage("1", "g"), newMessage("2", "g")}}}
+
+	release := make(chan struct{})
+	started := make(chan string, 2)
+	handler := func(ctx context.Context, msg *Message) {
+		started <- aws.StringValue(msg.MessageId)
+		<-release
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := New(client, "https://sqs/q.fifo", handler, Options{Concurrency: 2, MaxMessages: 10, Ordered: true, VisibilityTimeout: 60}, zap.NewNop())
+	r.heartbeatInterval = 5 * time.Millisecond
+	r.Start(ctx)
+
+	// 処理中のメッセージも、同じグループで順番を待つメッセージも延長する
+	if got := <-started; got != "1" {
+		t.Fatalf("started %s, want 1", got)
+	}
+	waitFor(t, "extensions while running", func() bool { return client.extensions("1") >= 2 })
+	waitFor(t, "extensions while waiting for the group", func() bool { return client.extensions("2") >= 2 })
+
+	// 処理が終われば延長をやめる
+	release <- struct{}{}
+	if got := <-started; got != "2" {
+		t.Fatalf("started %s, want 2", got)
+	}
+	time.Sleep(20 * time.Millisecond)
+	n := client.extensions("1")
+	time.Sleep(20 * time.Millisecond)
+	if got := client.extensions("1"); got != n {
+		t.Errorf("extended %d times after the handler returned", got-n)
+	}
+
+	// ctx がキャンセルされれば処理中でも延長をやめる
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	n = client.extensions("2")
+	time.Sleep(20 * time.Millisecond)
+	if got := client.extensions("2"); got != n {
+		t.Errorf("extended %d times after ctx was canceled", got-n)
+	}
+
+	close(release)
+	r.Stop()
+}
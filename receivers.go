@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/log"
+	"github.com/yuichiro-h/cwl-alert-notifier/receiver"
+	"go.uber.org/zap"
+)
+
+// alarmReceivers runs one receiver per alarm so that alarms can be added to or
+// removed from a running process.
+type alarmReceivers struct {
+	ctx       context.Context
+	svc       *services
+	mu        sync.Mutex
+	receivers map[config.AlarmName]*alarmReceiver
+	// stopping tracks receivers removed by Reconcile that are still draining.
+	stopping sync.WaitGroup
+}
+
+type alarmReceiver struct {
+	sqsURL   string
+	opts     receiver.Options
+	receiver *receiver.Receiver
+}
+
+// newAlarmReceivers returns alarmReceivers whose handlers are aborted when ctx is canceled.
+func newAlarmReceivers(ctx context.Context, svc *services) *alarmReceivers {
+	return &alarmReceivers{
+		ctx:       ctx,
+		svc:       svc,
+		receivers: map[config.AlarmName]*alarmReceiver{},
+	}
+}
+
+func receiveOptions(alarm config.Alarm) receiver.Options {
+	return receiver.Options{
+		Concurrency:       alarm.Receive.GetConcurrency(),
+		MaxMessages:       alarm.Receive.GetMaxMessages(),
+		WaitTimeSeconds:   alarm.Receive.GetWaitTimeSeconds(),
+		Ordered:           alarm.Receive.Ordered,
+		VisibilityTimeout: alarm.Receive.GetVisibilityTimeout(),
+	}
+}
+
+// Reconcile starts receivers for alarms added to conf, and restarts or stops
+// receivers for alarms whose queue settings changed or that were removed.
+func (r *alarmReceivers) Reconcile(conf *config.Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name, ar := range r.receivers {
+		alarm, ok := conf.Alarms[name]
+		if ok && alarm.SqsURL == ar.sqsURL && receiveOptions(alarm) == ar.opts {
+			continue
+		}
+		log.Get().Info("stop alarm", zap.String("alarm", string(name)), zap.String("sqs_url", ar.sqsURL))
+		// 処理中のメッセージを待つ間もシグナルを受け付けられるように非同期で止める
+		r.stopping.Add(1)
+		go func(name config.AlarmName, rcv *receiver.Receiver) {
+			defer r.stopping.Done()
+			rcv.Stop()
+			log.Get().Info("stopped alarm", zap.String("alarm", string(name)))
+		}(name, ar.receiver)
+		delete(r.receivers, name)
+	}
+
+	for _, name := range conf.AlarmNames() {
+		if _, ok := r.receivers[name]; ok {
+			continue
+		}
+		alarm := conf.Alarms[name]
+		opts := receiveOptions(alarm)
+		log.Get().Info("start alarm",
+			zap.String("alarm", string(name)),
+			zap.String("sqs_url", alarm.SqsURL),
+			zap.Int("concurrency", opts.Concurrency),
+			zap.Bool("ordered", opts.Ordered))
+
+		rcv := receiver.New(r.svc.sqsClient, alarm.SqsURL, NewAlarmHandler(name, r.svc), opts, log.Get())
+		rcv.Start(r.ctx)
+		r.receivers[name] = &alarmReceiver{
+			sqsURL:   alarm.SqsURL,
+			opts:     opts,
+			receiver: rcv,
+		}
+	}
+}
+
+// Stop stops every running receiver concurrently. Each receiver stops receiving
+// and returns after its in-flight messages have been handled. It also waits for
+// the receivers that Reconcile is still stopping.
+func (r *alarmReceivers) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for name, ar := range r.receivers {
+		wg.Add(1)
+		go func(rcv *receiver.Receiver) {
+			defer wg.Done()
+			rcv.Stop()
+		}(ar.receiver)
+		delete(r.receivers, name)
+	}
+	wg.Wait()
+	r.stopping.Wait()
+}
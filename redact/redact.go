@@ -0,0 +1,182 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Rule replaces every match of Pattern.
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// KeepPrefix keeps the text of the first group, such as the key of a
+	// key-value pair, in front of the replacement.
+	KeepPrefix bool
+	// Validate, when set, must accept a match for it to be replaced.
+	Validate func(match string) bool
+}
+
+// Builtin returns the built-in detectors by name.
+func Builtin() map[string]Rule {
+	rules := map[string]Rule{
+		"aws_access_key": {
+			Pattern: regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`),
+		},
+		"aws_secret_key": {
+			Pattern:    regexp.MustCompile(`(?i)(aws_?secret_?(?:access_?)?key["']?\s*[:=]\s*["']?)[A-Za-z0-9/+=]{40}`),
+			KeepPrefix: true,
+		},
+		"jwt": {
+			Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`),
+		},
+		"bearer_token": {
+			Pattern:    regexp.MustCompile(`(?i)\b(bearer\s+)[A-Za-z0-9\-._~+/]+=*`),
+			KeepPrefix: true,
+		},
+		"email": {
+			Pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`),
+		},
+		"credit_card": {
+			Pattern:  regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+			Validate: luhn,
+		},
+	}
+	for name, rule := range rules {
+		rule.Name = name
+		rules[name] = rule
+	}
+	return rules
+}
+
+// Redactor removes secrets and personal data from log messages.
+type Redactor struct {
+	rules    []Rule
+	jsonKeys map[string]bool
+}
+
+// New returns a Redactor applying rules to text, and replacing the whole value
+// of JSON object keys in jsonKeys, compared case-insensitively.
+func New(rules []Rule, jsonKeys []string) *Redactor {
+	keys := map[string]bool{}
+	for _, k := range jsonKeys {
+		keys[strings.ToLower(k)] = true
+	}
+	return &Redactor{
+		rules:    rules,
+		jsonKeys: keys,
+	}
+}
+
+// Placeholder returns what a match of the rule name is replaced with.
+func Placeholder(name string) string {
+	return "[REDACTED:" + name + "]"
+}
+
+// Redact returns msg with sensitive data replaced. A JSON object keeps its
+// structure and formatting is normalized.
+func (r *Redactor) Redact(msg string) string {
+	if r == nil || (len(r.rules) == 0 && len(r.jsonKeys) == 0) {
+		return msg
+	}
+
+	trimmed := strings.TrimSpace(msg)
+	if strings.HasPrefix(trimmed, "{") {
+		var obj map[string]interface{}
+		dec := json.NewDecoder(strings.NewReader(trimmed))
+		dec.UseNumber()
+		if err := dec.Decode(&obj); err == nil {
+			if data, err := r.marshal(r.redactValue(obj)); err == nil {
+				return data
+			}
+		}
+	}
+	return r.redactText(msg)
+}
+
+// RedactAll returns msgs redacted one by one.
+func (r *Redactor) RedactAll(msgs []string) []string {
+	if msgs == nil {
+		return nil
+	}
+	redacted := make([]string, len(msgs))
+	for i, msg := range msgs {
+		redacted[i] = r.Redact(msg)
+	}
+	return redacted
+}
+
+func (r *Redactor) marshal(v interface{}) (string, error) {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func (r *Redactor) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if r.jsonKeys[strings.ToLower(k)] {
+				v[k] = Placeholder(k)
+				continue
+			}
+			v[k] = r.redactValue(child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = r.redactValue(child)
+		}
+		return v
+	case string:
+		return r.redactText(v)
+	default:
+		return v
+	}
+}
+
+func (r *Redactor) redactText(s string) string {
+	for _, rule := range r.rules {
+		rule := rule
+		s = rule.Pattern.ReplaceAllStringFunc(s, func(m string) string {
+			if rule.Validate != nil && !rule.Validate(m) {
+				return m
+			}
+			if rule.KeepPrefix {
+				if sub := rule.Pattern.FindStringSubmatch(m); len(sub) > 1 {
+					return sub[1] + Placeholder(rule.Name)
+				}
+			}
+			return Placeholder(rule.Name)
+		})
+	}
+	return s
+}
+
+// luhn reports whether the digits of s pass the Luhn checksum.
+func luhn(s string) bool {
+	var sum, n int
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
@@ -0,0 +1,71 @@
+package render
+
+import (
+	"github.com/yuichiro-h/cwl-alert-notifier/fingerprint"
+)
+
+// Cluster merges events of the same fingerprint into the first of them, in
+// the order they first occurred. Merged events have Count, Last and
+// LogStreams set, and Timestamp is the first occurrence.
+func Cluster(events []Event) []Event {
+	var clusters []Event
+	index := map[string]int{}
+	for _, e := range events {
+		fp := e.Fingerprint()
+		i, ok := index[fp]
+		if !ok {
+			e.Count = 0
+			e.Last = e.Timestamp
+			e.LogStreams = nil
+			index[fp] = len(clusters)
+			clusters = append(clusters, e)
+			i = len(clusters) - 1
+		}
+
+		c := &clusters[i]
+		c.Count++
+		if e.Timestamp.Before(c.Timestamp) {
+			c.Timestamp = e.Timestamp
+		}
+		if e.Timestamp.After(c.Last) {
+			c.Last = e.Timestamp
+		}
+		if !contains(c.LogStreams, e.LogStreamName) {
+			c.LogStreams = append(c.LogStreams, e.LogStreamName)
+		}
+	}
+	return clusters
+}
+
+// Fingerprint identifies the kind of error of e. The summary of a structured
+// log is used so that fields such as request ids do not tell errors apart.
+func (e *Event) Fingerprint() string {
+	if e.Summary != "" {
+		return fingerprint.Of(e.Summary + "\n" + e.Stack)
+	}
+	return fingerprint.Of(e.Message)
+}
+
+// Summarize counts clustered events that are not shown.
+func Summarize(clusters []Event) *Remainder {
+	r := Remainder{Fingerprints: len(clusters)}
+	for _, c := range clusters {
+		r.Count += c.Count
+		if r.First.IsZero() || c.Timestamp.Before(r.First) {
+			r.First = c.Timestamp
+		}
+		if c.Last.After(r.Last) {
+			r.Last = c.Last
+		}
+	}
+	return &r
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
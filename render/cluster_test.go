@@ -0,0 +1,71 @@
+package render
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func at(sec int) time.Time {
+	return time.Date(2026, 10, 18, 9, 0, sec, 0, time.UTC)
+}
+
+// jsonEvent returns a structured log event with its summary extracted.
+func jsonEvent(t *testing.T, msg, stream string, ts time.Time) Event {
+	t.Helper()
+	e := Event{Message: msg, LogStreamName: stream, Timestamp: ts}
+	if err := json.Unmarshal([]byte(msg), &e.Fields); err != nil {
+		t.Fatal(err)
+	}
+	e.Extract(FieldMapping{Message: []string{"message"}, Stack: []string{"stack"}})
+	return e
+}
+
+func TestCluster(t *testing.T) {
+	events := []Event{
+		{Message: "2026-10-18T09:00:03Z order 42 failed", LogStreamName: "a", Timestamp: at(3)},
+		{Message: "connection reset by 10.0.1.23:5432", LogStreamName: "a", Timestamp: at(4)},
+		{Message: "2026-10-18T09:00:01Z order 1337 failed", LogStreamName: "b", Timestamp: at(1)},
+		{Message: "2026-10-18T09:00:09Z order 7 failed", LogStreamName: "a", Timestamp: at(9)},
+		jsonEvent(t, `{"message":"payment declined","request_id":"r-1","stack":"at Pay.charge"}`, "a", at(5)),
+		jsonEvent(t, `{"message":"payment declined","request_id":"r-2","stack":"at Pay.charge","time":"x"}`, "c", at(6)),
+		jsonEvent(t, `{"message":"payment declined","request_id":"r-3","stack":"at Pay.refund"}`, "a", at(7)),
+	}
+
+	clusters := Cluster(events)
+
+	type cluster struct {
+		Message    string
+		Count      int
+		First      time.Time
+		Last       time.Time
+		LogStreams []string
+	}
+	var got []cluster
+	for _, c := range clusters {
+		got = append(got, cluster{c.Message, c.Count, c.Timestamp, c.Last, c.LogStreams})
+	}
+	want := []cluster{
+		{events[0].Message, 3, at(1), at(9), []string{"a", "b"}},
+		{events[1].Message, 1, at(4), at(4), []string{"a"}},
+		// 構造化ログはメッセージとスタックだけで比べる
+		{events[4].Message, 2, at(5), at(6), []string{"a", "c"}},
+		{events[6].Message, 1, at(7), at(7), []string{"a"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Cluster() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	clusters := []Event{
+		{Count: 3, Timestamp: at(5), Last: at(8)},
+		{Count: 1, Timestamp: at(2), Last: at(2)},
+		{Count: 4, Timestamp: at(6), Last: at(12)},
+	}
+	want := &Remainder{Count: 8, Fingerprints: 3, First: at(2), Last: at(12)}
+	if got := Summarize(clusters); !reflect.DeepEqual(got, want) {
+		t.Errorf("Summarize() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// FieldMapping tells which JSON paths of a structured log hold what. Each
+// list is tried in order and the first path with a value wins.
+type FieldMapping struct {
+	Message []string
+	Level   []string
+	Stack   []string
+	// Context are paths shown as key/value pairs.
+	Context []string
+}
+
+// Field is a key/value pair of a structured log.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Extract fills the summary of e from its JSON fields. It does nothing for
+// logs that are not JSON objects or have no message field.
+func (e *Event) Extract(m FieldMapping) {
+	if e.Fields == nil {
+		return
+	}
+	message, ok := lookup(e.Fields, m.Message)
+	if !ok {
+		return
+	}
+
+	e.Summary = message
+	e.Level, _ = lookup(e.Fields, m.Level)
+	e.Stack, _ = lookup(e.Fields, m.Stack)
+	e.Context = nil
+	for _, path := range m.Context {
+		if v, ok := lookup(e.Fields, []string{path}); ok {
+			e.Context = append(e.Context, Field{Key: path, Value: v})
+		}
+	}
+}
+
+func lookup(fields map[string]interface{}, paths []string) (string, bool) {
+	for _, path := range paths {
+		switch v := JSONPath(path, fields).(type) {
+		case nil:
+			continue
+		case string:
+			if v == "" {
+				continue
+			}
+			return v, true
+		case []interface{}:
+			// スタックトレースは行の配列で出力されることがある
+			lines := make([]string, len(v))
+			for i, l := range v {
+				lines[i] = fmt.Sprint(l)
+			}
+			return strings.Join(lines, "\n"), true
+		default:
+			data, err := json.Marshal(v)
+			if err != nil {
+				continue
+			}
+			return string(data), true
+		}
+	}
+	return "", false
+}
+
+// Collapse keeps the first n lines of s and tells how many were cut.
+func Collapse(n int, s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) <= n {
+		return s
+	}
+	return fmt.Sprintf("%s\n… %d more line(s)", strings.Join(lines[:n], "\n"), len(lines)-n)
+}
@@ -0,0 +1,255 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultTitle = "{{ range .Mentions }}{{ . }} {{ end }}Found log in *{{ .ApplicationName }}*"
+	DefaultBody  = "{{ range .Events }}{{ .Text }}\n" +
+		"{{ if gt .Count 1 }}_×{{ .Count }} from {{ formatTime \"15:04:05\" \"Local\" .Timestamp }} " +
+		"to {{ formatTime \"15:04:05\" \"Local\" .Last }} in `{{ join \"`, `\" .LogStreams }}`_\n{{ end }}{{ end }}" +
+		"{{ with .Remainder }}_" +
+		"{{ if .Count }}…and {{ .Count }} more event(s) of {{ .Fingerprints }} kind(s) " +
+		"from {{ formatTime \"15:04:05\" \"Local\" .First }} to {{ formatTime \"15:04:05\" \"Local\" .Last }}." +
+		"{{ if .Truncated }} {{ end }}{{ end }}" +
+		"{{ if .Truncated }}More events were not fetched.{{ end }}" +
+		"_ <{{ $.ConsoleURL }}|View all>\n{{ end }}"
+	DefaultEvent = "{{ range .Before }}> `{{ . }}`\n{{ end }}" +
+		"{{ if .Summary }}{{ if .Level }}*{{ upper .Level }}* {{ end }}{{ .Summary }}" +
+		"{{ range .Context }}\n• {{ .Key }}: `{{ .Value }}`{{ end }}" +
+		"{{ if .Stack }}\n```{{ collapse 5 .Stack }}```{{ end }}" +
+		"{{ else }}```{{ .Body }}```{{ end }}" +
+		"{{ range .After }}\n> `{{ . }}`{{ end }}"
+)
+
+// Message is the data of the title and body templates.
+type Message struct {
+	// Alarm is the CloudWatch alarm notification with fields such as
+	// AlarmName, NewStateReason and Trigger.
+	Alarm           interface{}
+	ApplicationName string
+	LogGroupName    string
+	FirstLogURL     string
+	// Severity is one of critical, error, warning and info.
+	Severity string
+	// Mentions are Slack mentions such as "<!channel>" for the severity.
+	Mentions []string
+	// ConsoleURL shows all the log events of the alarm in the console.
+	ConsoleURL string
+	Events     []Event
+	// Remainder summarizes the events not in Events. It is nil when all are shown.
+	Remainder *Remainder
+}
+
+// Remainder summarizes log events that are not shown.
+type Remainder struct {
+	Count        int
+	Fingerprints int
+	First        time.Time
+	Last         time.Time
+	// Truncated tells that more events matched than were fetched.
+	Truncated bool
+}
+
+// Event is the data of the event template.
+type Event struct {
+	// Message is the log message as is, Body is it formatted for display.
+	Message string
+	Body    string
+	// Fields holds the parsed log message when it is a JSON object.
+	Fields map[string]interface{}
+	// Summary, Level, Stack and Context are extracted from Fields, see Extract.
+	// Summary is empty when the log has no message field.
+	Summary string
+	Level   string
+	Stack   string
+	Context []Field
+	// Before and After are the lines of the log stream around the event.
+	Before        []string
+	After         []string
+	Timestamp     time.Time
+	LogStreamName string
+	// Count, Last and LogStreams are set on events merged by Cluster.
+	Count      int
+	Last       time.Time
+	LogStreams []string
+	// Text is the event rendered by the event template, for the body template.
+	Text string
+}
+
+// Templates renders notifications.
+type Templates struct {
+	title *template.Template
+	body  *template.Template
+	event *template.Template
+}
+
+// Compile parses the templates, using the default of each empty one.
+func Compile(title, body, event string) (*Templates, error) {
+	if title == "" {
+		title = DefaultTitle
+	}
+	if body == "" {
+		body = DefaultBody
+	}
+	if event == "" {
+		event = DefaultEvent
+	}
+
+	var t Templates
+	var err error
+	if t.title, err = parse("title", title); err != nil {
+		return nil, err
+	}
+	if t.body, err = parse("body", body); err != nil {
+		return nil, err
+	}
+	if t.event, err = parse("event", event); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func parse(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Funcs(Funcs()).Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s template", name)
+	}
+	return t, nil
+}
+
+// Render returns the title and body of m, rendering each event first.
+func (t *Templates) Render(m Message) (string, string, error) {
+	events := make([]Event, len(m.Events))
+	for i, e := range m.Events {
+		text, err := execute(t.event, e)
+		if err != nil {
+			return "", "", err
+		}
+		e.Text = text
+		events[i] = e
+	}
+	m.Events = events
+
+	title, err := execute(t.title, m)
+	if err != nil {
+		return "", "", err
+	}
+	body, err := execute(t.body, m)
+	if err != nil {
+		return "", "", err
+	}
+	return title, body, nil
+}
+
+// Plain renders m like the default templates but without them, for when even
+// those fail.
+func Plain(m Message) (string, string) {
+	title := "Found log in *" + m.ApplicationName + "*"
+	if len(m.Mentions) > 0 {
+		title = strings.Join(m.Mentions, " ") + " " + title
+	}
+
+	body := strings.Builder{}
+	for _, e := range m.Events {
+		fmt.Fprintf(&body, "```%s```\n", e.Body)
+		if e.Count > 1 {
+			fmt.Fprintf(&body, "_×%d in `%s`_\n", e.Count, strings.Join(e.LogStreams, "`, `"))
+		}
+	}
+	if r := m.Remainder; r != nil {
+		body.WriteString("_")
+		if r.Count > 0 {
+			fmt.Fprintf(&body, "…and %d more event(s) of %d kind(s).", r.Count, r.Fingerprints)
+			if r.Truncated {
+				body.WriteString(" ")
+			}
+		}
+		if r.Truncated {
+			body.WriteString("More events were not fetched.")
+		}
+		fmt.Fprintf(&body, "_ <%s|View all>\n", m.ConsoleURL)
+	}
+	return title, body.String()
+}
+
+func execute(t *template.Template, data interface{}) (string, error) {
+	buf := strings.Builder{}
+	if err := t.Execute(&buf, data); err != nil {
+		return "", errors.Wrapf(err, "failed to render %s template", t.Name())
+	}
+	return buf.String(), nil
+}
+
+// Funcs returns the helper functions available in templates.
+func Funcs() template.FuncMap {
+	return template.FuncMap{
+		"truncate":   Truncate,
+		"collapse":   Collapse,
+		"jsonPath":   JSONPath,
+		"formatTime": FormatTime,
+		"toJSON":     toJSON,
+		"join":       func(sep string, s []string) string { return strings.Join(s, sep) },
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"default": func(def, v interface{}) interface{} {
+			if v == nil || v == "" {
+				return def
+			}
+			return v
+		},
+	}
+}
+
+// Truncate shortens s to n characters, marking that it was cut.
+func Truncate(n int, s string) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
+
+// JSONPath returns the value at a dot separated path such as "error.stack",
+// or nil when there is none. Array elements are selected by index.
+func JSONPath(path string, v interface{}) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			var i int
+			if _, err := fmt.Sscanf(key, "%d", &i); err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// FormatTime formats t with layout in the IANA time zone tz.
+func FormatTime(layout, tz string, t time.Time) (string, error) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return t.In(loc).Format(layout), nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,58 @@
+package render
+
+import "testing"
+
+func TestPlain(t *testing.T) {
+	tests := []struct {
+		name      string
+		m         Message
+		wantTitle string
+		wantBody  string
+	}{
+		{
+			name: "single event",
+			m: Message{
+				ApplicationName: "app",
+				Events:          []Event{{Body: "error"}},
+			},
+			wantTitle: "Found log in *app*",
+			wantBody:  "```error```\n",
+		},
+		{
+			name: "clusters and remainder",
+			m: Message{
+				ApplicationName: "app",
+				Mentions:        []string{"<!here>", "<@U1>"},
+				ConsoleURL:      "https://console",
+				Events: []Event{
+					{Body: "error 1", Count: 3, LogStreams: []string{"a", "b"}},
+					{Body: "error 2", Count: 1},
+				},
+				Remainder: &Remainder{Count: 4, Fingerprints: 2, Truncated: true},
+			},
+			wantTitle: "<!here> <@U1> Found log in *app*",
+			wantBody: "```error 1```\n_×3 in `a`, `b`_\n```error 2```\n" +
+				"_…and 4 more event(s) of 2 kind(s). More events were not fetched._ <https://console|View all>\n",
+		},
+		{
+			name: "truncated only",
+			m: Message{
+				ApplicationName: "app",
+				ConsoleURL:      "https://console",
+				Events:          []Event{{Body: "error"}},
+				Remainder:       &Remainder{Truncated: true},
+			},
+			wantTitle: "Found log in *app*",
+			wantBody:  "```error```\n_More events were not fetched._ <https://console|View all>\n",
+		},
+	}
+	for _, tt := range tests {
+		title, body := Plain(tt.m)
+		if title != tt.wantTitle {
+			t.Errorf("%s: title = %q, want %q", tt.name, title, tt.wantTitle)
+		}
+		if body != tt.wantBody {
+			t.Errorf("%s: body = %q, want %q", tt.name, body, tt.wantBody)
+		}
+	}
+}
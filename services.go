@@ -0,0 +1,52 @@
+package main
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/silence"
+	"github.com/yuichiro-h/cwl-alert-notifier/state"
+)
+
+// services are shared by alarm handlers and the HTTP API.
+type services struct {
+	sqsClient  sqsiface.SQSAPI
+	cwl        cloudwatchlogsiface.CloudWatchLogsAPI
+	store      state.Store
+	deliveries *deliveries
+	silencer   *silence.Silencer
+	limiter    *rateLimiter
+	stats      *alertStats
+	alarmTags  *alarmTags
+	mentions   *mentionResolver
+	history    *history
+}
+
+func newServices(sess *session.Session, store state.Store) *services {
+	return &services{
+		sqsClient:  sqs.New(sess),
+		cwl:        cloudwatchlogs.New(sess),
+		store:      store,
+		deliveries: &deliveries{store: store},
+		silencer:   silence.New(store),
+		limiter:    newRateLimiter(),
+		stats:      &alertStats{store: store},
+		alarmTags:  &alarmTags{api: resourcegroupstaggingapi.New(sess)},
+		mentions:   newMentionResolver(),
+		history:    &history{store: store},
+	}
+}
+
+// allow reports whether a notification to sink is within the configured rate limit.
+func (s *services) allow(conf *config.Config, sink string, now time.Time) bool {
+	if conf.RateLimit.PerMinute == nil {
+		return true
+	}
+	return s.limiter.Allow(sink, *conf.RateLimit.PerMinute, conf.RateLimit.GetBurst(), now)
+}
@@ -0,0 +1,141 @@
+package silence
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/gobwas/glob"
+	"github.com/pkg/errors"
+)
+
+// Silence mutes notifications of events that match all of its non-empty matchers
+// between StartsAt and EndsAt.
+type Silence struct {
+	ID string `yaml:"id" json:"id"`
+
+	// Alarm is a glob matched against the CloudWatch alarm name.
+	Alarm string `yaml:"alarm" json:"alarm,omitempty"`
+	// App is a glob matched against the application name shown in notifications.
+	App string `yaml:"app" json:"app,omitempty"`
+	// LogGroup is a glob matched against the log group name.
+	LogGroup string `yaml:"log_group" json:"log_group,omitempty"`
+	// Message is a regular expression matched against the log message.
+	Message string `yaml:"message" json:"message,omitempty"`
+	// Fingerprint is matched against the error fingerprint shown in notifications.
+	Fingerprint string `yaml:"fingerprint" json:"fingerprint,omitempty"`
+
+	// StartsAt is optional; a zero value means the silence is active from creation.
+	StartsAt  time.Time `yaml:"starts_at" json:"starts_at"`
+	EndsAt    time.Time `yaml:"ends_at" json:"ends_at"`
+	CreatedBy string    `yaml:"created_by" json:"created_by,omitempty"`
+	Comment   string    `yaml:"comment" json:"comment,omitempty"`
+
+	// matchers are compiled by Validate.
+	matchers *matchers
+}
+
+type matchers struct {
+	alarm, app, logGroup glob.Glob
+	message              *regexp.Regexp
+}
+
+// Event is what a silence is matched against.
+type Event struct {
+	AlarmName   string
+	AppName     string
+	LogGroup    string
+	Message     string
+	Fingerprint string
+}
+
+// Validate reports the first problem of s and compiles its matchers.
+func (s *Silence) Validate() error {
+	if s.Alarm == "" && s.App == "" && s.LogGroup == "" && s.Message == "" && s.Fingerprint == "" {
+		return errors.New("at least one of alarm, app, log_group, message or fingerprint is required")
+	}
+	if s.EndsAt.IsZero() {
+		return errors.New("ends_at is required")
+	}
+	if !s.StartsAt.IsZero() && !s.StartsAt.Before(s.EndsAt) {
+		return errors.New("starts_at must be before ends_at")
+	}
+	m, err := s.compile()
+	if err != nil {
+		return err
+	}
+	s.matchers = m
+	return nil
+}
+
+func (s *Silence) compile() (*matchers, error) {
+	var m matchers
+	for _, g := range []struct {
+		name    string
+		pattern string
+		glob    *glob.Glob
+	}{
+		{"alarm", s.Alarm, &m.alarm}, {"app", s.App, &m.app}, {"log_group", s.LogGroup, &m.logGroup},
+	} {
+		if g.pattern == "" {
+			continue
+		}
+		compiled, err := glob.Compile(g.pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s pattern %q", g.name, g.pattern)
+		}
+		*g.glob = compiled
+	}
+	if s.Message != "" {
+		re, err := regexp.Compile(s.Message)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid message pattern %q", s.Message)
+		}
+		m.message = re
+	}
+	return &m, nil
+}
+
+// Active reports whether s mutes events at t.
+func (s *Silence) Active(t time.Time) bool {
+	return !t.Before(s.StartsAt) && t.Before(s.EndsAt)
+}
+
+// Matches reports whether e matches every matcher of s. Silences that have
+// not been validated are compiled on every call, and invalid ones match nothing.
+func (s *Silence) Matches(e Event) bool {
+	m := s.matchers
+	if m == nil {
+		var err error
+		if m, err = s.compile(); err != nil {
+			return false
+		}
+	}
+	return matchGlob(m.alarm, e.AlarmName) &&
+		matchGlob(m.app, e.AppName) &&
+		matchGlob(m.logGroup, e.LogGroup) &&
+		(s.Fingerprint == "" || s.Fingerprint == e.Fingerprint) &&
+		(m.message == nil || m.message.MatchString(e.Message))
+}
+
+func matchGlob(g glob.Glob, s string) bool {
+	return g == nil || g.Match(s)
+}
+
+// String describes the matchers of s for humans.
+func (s *Silence) String() string {
+	var desc string
+	for _, m := range []struct{ name, value string }{
+		{"alarm", s.Alarm}, {"app", s.App}, {"log_group", s.LogGroup}, {"message", s.Message},
+		{"fingerprint", s.Fingerprint},
+	} {
+		if m.value == "" {
+			continue
+		}
+		if desc != "" {
+			desc += " "
+		}
+		desc += fmt.Sprintf("%s=%q", m.name, m.value)
+	}
+	return desc
+}
@@ -0,0 +1,70 @@
+package silence
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuichiro-h/cwl-alert-notifier/state"
+)
+
+func TestValidateReportsFirstPattern(t *testing.T) {
+	s := Silence{Alarm: "[", App: "[", LogGroup: "[", EndsAt: time.Now().Add(time.Hour)}
+	for i := 0; i < 10; i++ {
+		err := s.Validate()
+		if err == nil || !strings.HasPrefix(err.Error(), "invalid alarm pattern") {
+			t.Fatalf("Validate() = %v, want the alarm pattern reported", err)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	s := Silence{App: "api-*", Message: "timeout", EndsAt: time.Now().Add(time.Hour)}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		e    Event
+		want bool
+	}{
+		{Event{AppName: "api-1", Message: "read timeout"}, true},
+		{Event{AppName: "web-1", Message: "read timeout"}, false},
+		{Event{AppName: "api-1", Message: "refused"}, false},
+	}
+	for _, tt := range tests {
+		if got := s.Matches(tt.e); got != tt.want {
+			t.Errorf("Matches(%+v) = %v, want %v", tt.e, got, tt.want)
+		}
+	}
+
+	invalid := Silence{Message: "("}
+	if invalid.Matches(Event{Message: "("}) {
+		t.Error("an invalid silence matched")
+	}
+}
+
+func TestListSkipsInvalidSilences(t *testing.T) {
+	ctx := context.Background()
+	store := state.NewMemoryStore()
+	r := New(store)
+	s, err := r.Create(ctx, Silence{App: "api", EndsAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(`{"id":"bad","message":"(","ends_at":"2099-01-01T00:00:00Z"}`)
+	if err := store.Put(ctx, silenceKey("bad"), data, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	silences, err := r.List(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(silences) != 1 || silences[0].ID != s.ID {
+		t.Fatalf("List() = %+v, want only %s", silences, s.ID)
+	}
+	if Match(silences, Event{AppName: "api"}, time.Now()) == nil {
+		t.Error("listed silence did not match")
+	}
+}
@@ -0,0 +1,186 @@
+package silence
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yuichiro-h/cwl-alert-notifier/log"
+	"github.com/yuichiro-h/cwl-alert-notifier/state"
+	"go.uber.org/zap"
+)
+
+// retention is how long a silence and its statistics are kept after it ends.
+const retention = 7 * 24 * time.Hour
+
+// Silencer keeps runtime-created silences and suppression counts in a state store.
+type Silencer struct {
+	store state.Store
+	// mu serializes updates of suppression counts within the process.
+	mu sync.Mutex
+}
+
+// Stats is what happened while a silence was active.
+type Stats struct {
+	Suppressed int  `json:"suppressed"`
+	Reported   bool `json:"reported"`
+}
+
+func New(store state.Store) *Silencer {
+	return &Silencer{store: store}
+}
+
+func silenceKey(id string) string {
+	return "silence/" + id
+}
+
+func statsKey(id string) string {
+	return "silencestats/" + id
+}
+
+func newID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ttl keeps entries of s until retention has passed since it ended.
+func ttl(s Silence, now time.Time) time.Duration {
+	d := s.EndsAt.Add(retention).Sub(now)
+	if d < time.Second {
+		// 0 would mean no expiry
+		return time.Second
+	}
+	return d
+}
+
+// Create stores s, assigning an ID when it has none.
+func (r *Silencer) Create(ctx context.Context, s Silence) (Silence, error) {
+	if s.ID == "" {
+		s.ID = newID()
+	}
+	if err := s.Validate(); err != nil {
+		return Silence{}, err
+	}
+	if err := r.put(ctx, s); err != nil {
+		return Silence{}, err
+	}
+	return s, nil
+}
+
+func (r *Silencer) put(ctx context.Context, s Silence) error {
+	data, err := json.Marshal(&s)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(r.store.Put(ctx, silenceKey(s.ID), data, ttl(s, time.Now())))
+}
+
+// Expire ends the runtime-created silence id now.
+func (r *Silencer) Expire(ctx context.Context, id string) (Silence, error) {
+	data, err := r.store.Get(ctx, silenceKey(id))
+	if err != nil {
+		return Silence{}, errors.WithStack(err)
+	}
+	var s Silence
+	if err := json.Unmarshal(data, &s); err != nil {
+		return Silence{}, errors.WithStack(err)
+	}
+
+	now := time.Now()
+	if s.EndsAt.After(now) {
+		s.EndsAt = now
+		if s.StartsAt.After(now) {
+			s.StartsAt = now
+		}
+		if err := r.put(ctx, s); err != nil {
+			return Silence{}, err
+		}
+	}
+	return s, nil
+}
+
+// List returns configured and runtime-created silences ordered by end time.
+func (r *Silencer) List(ctx context.Context, configured []Silence) ([]Silence, error) {
+	items, err := r.store.List(ctx, silenceKey(""))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	silences := append([]Silence(nil), configured...)
+	for _, item := range items {
+		var s Silence
+		if err := json.Unmarshal(item.Value, &s); err != nil {
+			return nil, errors.Wrapf(err, "broken silence %s", item.Key)
+		}
+		if err := s.Validate(); err != nil {
+			log.Get().Error("invalid silence", zap.String("key", item.Key), zap.Error(err))
+			continue
+		}
+		silences = append(silences, s)
+	}
+	sort.SliceStable(silences, func(i, j int) bool { return silences[i].EndsAt.Before(silences[j].EndsAt) })
+	return silences, nil
+}
+
+// Match returns the first silence active at now that matches e, or nil.
+func Match(silences []Silence, e Event, now time.Time) *Silence {
+	for i := range silences {
+		if silences[i].Active(now) && silences[i].Matches(e) {
+			return &silences[i]
+		}
+	}
+	return nil
+}
+
+// Stats returns the statistics of silence id.
+func (r *Silencer) Stats(ctx context.Context, id string) (Stats, error) {
+	var stats Stats
+	data, err := r.store.Get(ctx, statsKey(id))
+	if err == state.ErrNotFound {
+		return stats, nil
+	}
+	if err != nil {
+		return stats, errors.WithStack(err)
+	}
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return stats, errors.WithStack(err)
+	}
+	return stats, nil
+}
+
+// AddSuppressed adds n to the number of events suppressed by s.
+func (r *Silencer) AddSuppressed(ctx context.Context, s Silence, n int) error {
+	return r.updateStats(ctx, s, func(stats *Stats) {
+		stats.Suppressed += n
+	})
+}
+
+// MarkReported records that the summary of s has been posted.
+func (r *Silencer) MarkReported(ctx context.Context, s Silence) error {
+	return r.updateStats(ctx, s, func(stats *Stats) {
+		stats.Reported = true
+	})
+}
+
+func (r *Silencer) updateStats(ctx context.Context, s Silence, update func(*Stats)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats, err := r.Stats(ctx, s.ID)
+	if err != nil {
+		return err
+	}
+	update(&stats)
+
+	data, err := json.Marshal(&stats)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(r.store.Put(ctx, statsKey(s.ID), data, ttl(s, time.Now())))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/log"
+	"go.uber.org/zap"
+)
+
+// silenceReportWindow is how long after a silence ends its summary is still
+// posted. Older silences, such as past ones in the config at startup, are skipped.
+const silenceReportWindow = time.Hour
+
+// reportExpiredSilences posts a summary of each silence that has just expired.
+func reportExpiredSilences(ctx context.Context, svc *services, conf *config.Config) error {
+	silences, err := svc.silencer.List(ctx, conf.Silences.Rules)
+	if err != nil {
+		return err
+	}
+
+	slack := conf.Slack
+	slack.Merge(conf.Silences.SummarySlack)
+
+	now := time.Now()
+	for _, s := range silences {
+		if s.EndsAt.After(now) || now.Sub(s.EndsAt) > silenceReportWindow {
+			continue
+		}
+		stats, err := svc.silencer.Stats(ctx, s.ID)
+		if err != nil {
+			return err
+		}
+		if stats.Reported {
+			continue
+		}
+
+		if slack.Channel != "" {
+			text := fmt.Sprintf("Silence `%s` expired, %d log event(s) were suppressed", s.ID, stats.Suppressed)
+			detail := s.String()
+			if s.Comment != "" {
+				detail += "\n" + s.Comment
+			}
+			if err := notifyText(ctx, slack, text, detail); err != nil {
+				return err
+			}
+		}
+		log.Get().Info("silence expired",
+			zap.String("silence_id", s.ID),
+			zap.String("silence", s.String()),
+			zap.Int("suppressed", stats.Suppressed))
+
+		if err := svc.silencer.MarkReported(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
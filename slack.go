@@ -1,41 +1,135 @@
 package main
 
 import (
-	"fmt"
-	"strings"
+	"context"
+	"time"
 
 	"github.com/nlopes/slack"
 	"github.com/pkg/errors"
 	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/log"
+	"github.com/yuichiro-h/cwl-alert-notifier/render"
+	"go.uber.org/zap"
 )
 
 type notifyInput struct {
+	AlarmName config.AlarmName
+	// GroupIndex is the index of the matched group in the alarm, or -1.
+	GroupIndex int
+	// DigestUntil, when set, defers the notification to a digest posted at that time.
+	DigestUntil time.Time
+
+	Alarm           *CloudWatchAlarm
 	ApplicationName string
+	LogGroupName    string
 	Slack           config.SlackConfig
+	Templates       config.TemplateConfig
 	FirstLogURL     string
-	Body            []string
+	ConsoleURL      string
+	Events          []render.Event
+	// MaxDisplayed is how many clusters of Events are shown, all of them when 0.
+	MaxDisplayed int
+	// Truncated tells that more events matched than were fetched.
+	Truncated bool
+
+	// RouteSlack is merged over Slack by the active window, taking precedence
+	// over the severity.
+	RouteSlack config.SlackConfig
+	Severity   string
+	// Mentions are formatted for Slack once the severity is known.
+	Mentions []string
+	Delivery string
+	Sinks    []string
+}
+
+// slackToken returns the current api token for notifications of the group at
+// groupIndex of alarm. It is used for posts about stored notifications, whose
+// token is not stored.
+func slackToken(conf *config.Config, name config.AlarmName, groupIndex int) string {
+	alarm, ok := conf.Alarms[name]
+	if !ok {
+		return conf.Slack.ApiToken
+	}
+	var group *config.AlarmGroup
+	if groupIndex >= 0 && groupIndex < len(alarm.Groups) {
+		group = &alarm.Groups[groupIndex]
+	}
+	return conf.EffectiveSlack(alarm, group).ApiToken
+}
+
+// Sink identifies the destination of the notification.
+func (in *notifyInput) Sink() string {
+	return "slack:" + in.Slack.Channel
 }
 
-func notify(in *notifyInput) error {
-	body := strings.Builder{}
-	for _, b := range in.Body {
-		body.WriteString("```")
-		body.WriteString(b)
-		body.WriteString("```")
-		body.WriteString("\n")
+// render returns the title and body of the notification. When the configured
+// templates fail on the data, the default templates are used instead, and
+// plain text when those fail too, so that the notification is not lost.
+func (in *notifyInput) render() (string, string) {
+	m := render.Message{
+		Alarm:           in.Alarm,
+		ApplicationName: in.ApplicationName,
+		LogGroupName:    in.LogGroupName,
+		FirstLogURL:     in.FirstLogURL,
+		ConsoleURL:      in.ConsoleURL,
+		Severity:        in.Severity,
+		Mentions:        in.Mentions,
+		Events:          render.Cluster(in.Events),
+	}
+	if in.MaxDisplayed > 0 && len(m.Events) > in.MaxDisplayed {
+		m.Remainder = render.Summarize(m.Events[in.MaxDisplayed:])
+		m.Events = m.Events[:in.MaxDisplayed]
+	}
+	if in.Truncated {
+		if m.Remainder == nil {
+			m.Remainder = &render.Remainder{}
+		}
+		m.Remainder.Truncated = true
+	}
+
+	templates, err := in.Templates.Compile()
+	if err == nil {
+		var title, body string
+		title, body, err = templates.Render(m)
+		if err == nil {
+			return title, body
+		}
+	}
+	log.Get().Error("failed to render notification, using default templates",
+		zap.String("app_name", in.ApplicationName),
+		zap.Error(err))
+
+	templates, err = config.TemplateConfig{}.Compile()
+	if err == nil {
+		var title, body string
+		title, body, err = templates.Render(m)
+		if err == nil {
+			return title, body
+		}
 	}
+	log.Get().Error("failed to render notification with default templates, using plain text",
+		zap.String("app_name", in.ApplicationName),
+		zap.Error(err))
+	return render.Plain(m)
+}
+
+// notify posts in to Slack. actions are interactive buttons identified by
+// callbackID, which may be empty.
+func notify(ctx context.Context, in *notifyInput, callbackID string, actions []slack.AttachmentAction) error {
+	title, body := in.render()
 
 	attachment := slack.Attachment{
 		Color:      in.Slack.AttachmentColor,
 		MarkdownIn: []string{"text"},
-		Text:       body.String(),
-		Actions: []slack.AttachmentAction{
+		Text:       body,
+		CallbackID: callbackID,
+		Actions: append([]slack.AttachmentAction{
 			{
 				Type: "button",
 				Text: "Open Head Log",
 				URL:  in.FirstLogURL,
 			},
-		},
+		}, actions...),
 	}
 
 	params := slack.PostMessageParameters{
@@ -45,11 +139,48 @@ func notify(in *notifyInput) error {
 		Attachments: []slack.Attachment{attachment},
 	}
 
-	text := fmt.Sprintf("Found log in *%s*", in.ApplicationName)
-	_, _, err := slack.New(in.Slack.ApiToken).PostMessage(in.Slack.Channel, text, params)
-	if err != nil {
-		return errors.WithStack(err)
+	return postMessage(ctx, in.Slack, title, params)
+}
+
+// notifyText posts a plain message with detail as a code block.
+func notifyText(ctx context.Context, sc config.SlackConfig, text, detail string) error {
+	params := slack.PostMessageParameters{
+		Markdown: true,
+		Username: sc.Username,
+		IconURL:  sc.IconURL,
+		Attachments: []slack.Attachment{
+			{
+				Color:      sc.AttachmentColor,
+				MarkdownIn: []string{"text"},
+				Text:       "```" + detail + "```",
+			},
+		},
 	}
 
-	return nil
+	return postMessage(ctx, sc, text, params)
+}
+
+// maxRateLimitedRetries is how many times a post is retried after Slack
+// responded with 429 Too Many Requests.
+const maxRateLimitedRetries = 3
+
+// postMessage posts to Slack, waiting as long as Slack asks when rate limited.
+func postMessage(ctx context.Context, sc config.SlackConfig, text string, params slack.PostMessageParameters) error {
+	api := slack.New(sc.ApiToken)
+	for i := 0; ; i++ {
+		_, _, err := api.PostMessageContext(ctx, sc.Channel, text, params)
+		if err == nil {
+			return nil
+		}
+
+		rateLimited, ok := err.(*slack.RateLimitedError)
+		if !ok || i >= maxRateLimitedRetries {
+			return errors.WithStack(err)
+		}
+		select {
+		case <-time.After(rateLimited.RetryAfter):
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		}
+	}
 }
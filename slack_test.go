@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/render"
+)
+
+// fakeSlack records the messages posted to the Slack API.
+type fakeSlack struct {
+	mu    sync.Mutex
+	posts []url.Values
+}
+
+// newFakeSlack points the Slack client at a fake API until the returned func
+// is called.
+func newFakeSlack(t *testing.T) (*fakeSlack, func()) {
+	t.Helper()
+	f := &fakeSlack{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		f.mu.Lock()
+		f.posts = append(f.posts, r.PostForm)
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true,"channel":"C1","ts":"1.1"}`))
+	}))
+	prev := slack.SLACK_API
+	slack.SLACK_API = srv.URL + "/"
+	return f, func() {
+		slack.SLACK_API = prev
+		srv.Close()
+	}
+}
+
+// Posts returns the messages posted so far.
+func (f *fakeSlack) Posts() []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]url.Values(nil), f.posts...)
+}
+
+func TestNotifyInputRenderFallsBack(t *testing.T) {
+	in := &notifyInput{
+		Alarm:           &CloudWatchAlarm{AlarmName: "alarm"},
+		ApplicationName: "app",
+		Templates:       config.TemplateConfig{Title: "{{ .Alarm.Missing }}"},
+		Events:          []render.Event{{Message: "error", Body: "error"}},
+	}
+	title, body := in.render()
+	if title != "Found log in *app*" || body != "```error```\n" {
+		t.Errorf("render() = %q, %q, want the default templates", title, body)
+	}
+}
+
+func TestNotifyInputRenderRemainder(t *testing.T) {
+	var events []render.Event
+	for i := 0; i < 5; i++ {
+		for j := 0; j <= i; j++ {
+			events = append(events, render.Event{
+				Message:       fmt.Sprintf("error %c", 'a'+i),
+				Body:          fmt.Sprintf("error %c", 'a'+i),
+				Timestamp:     time.Date(2026, 10, 18, 9, i, j, 0, time.UTC),
+				LogStreamName: "s",
+			})
+		}
+	}
+	templates := config.TemplateConfig{
+		Body: "{{ range .Events }}{{ .Message }} ×{{ .Count }}\n{{ end }}" +
+			"{{ with .Remainder }}{{ .Count }} in {{ .Fingerprints }} from {{ .First.Format \"15:04:05\" }} " +
+			"to {{ .Last.Format \"15:04:05\" }} truncated={{ .Truncated }}{{ end }}",
+	}
+
+	tests := []struct {
+		maxDisplayed int
+		truncated    bool
+		want         string
+	}{
+		{0, false, "error a ×1\nerror b ×2\nerror c ×3\nerror d ×4\nerror e ×5\n"},
+		{5, false, "error a ×1\nerror b ×2\nerror c ×3\nerror d ×4\nerror e ×5\n"},
+		{2, false, "error a ×1\nerror b ×2\n12 in 3 from 09:02:00 to 09:04:04 truncated=false"},
+		{2, true, "error a ×1\nerror b ×2\n12 in 3 from 09:02:00 to 09:04:04 truncated=true"},
+		{5, true, "error a ×1\nerror b ×2\nerror c ×3\nerror d ×4\nerror e ×5\n0 in 0 from 00:00:00 to 00:00:00 truncated=true"},
+	}
+	for _, tt := range tests {
+		in := &notifyInput{
+			ApplicationName: "app",
+			Templates:       templates,
+			Events:          events,
+			MaxDisplayed:    tt.maxDisplayed,
+			Truncated:       tt.truncated,
+		}
+		if _, body := in.render(); body != tt.want {
+			t.Errorf("render() with max %d, truncated %v = %q, want %q", tt.maxDisplayed, tt.truncated, body, tt.want)
+		}
+	}
+}
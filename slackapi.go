@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+)
+
+// slackRequestMaxAge rejects replayed Slack requests.
+const slackRequestMaxAge = 5 * time.Minute
+
+// slackRequestMaxBytes limits the body of Slack requests.
+const slackRequestMaxBytes = 1 << 20
+
+// verifySlack lets through requests signed with http.slack_signing_secret.
+// The Slack endpoints are disabled when it is not set.
+func (a *apiServer) verifySlack(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := config.Get().HTTP.SlackSigningSecret
+		if secret == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, slackRequestMaxBytes))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.WithStack(err))
+			return
+		}
+		if err := verifySlackSignature(secret, r.Header, body, time.Now()); err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifySlackSignature checks the signature of a Slack request, see
+// https://api.slack.com/authentication/verifying-requests-from-slack.
+func verifySlackSignature(secret string, header http.Header, body []byte, now time.Time) error {
+	ts := header.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("invalid slack request timestamp")
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > slackRequestMaxAge || d < -slackRequestMaxAge {
+		return errors.New("expired slack request")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature"))) {
+		return errors.New("invalid slack signature")
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/render"
+	"github.com/yuichiro-h/cwl-alert-notifier/silence"
+	"github.com/yuichiro-h/cwl-alert-notifier/state"
+)
+
+const testSigningSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+// loadTestConfig loads a config with the HTTP API and the Slack endpoints enabled.
+func loadTestConfig(t *testing.T) {
+	t.Helper()
+	loadConfig(t, `aws:
+  region: ap-northeast-1
+http:
+  listen: ":8080"
+  api_token: api-token
+  slack_signing_secret: `+testSigningSecret+`
+slack:
+  api_token: xoxb-token
+  channel: "#alerts"
+alarms:
+  a:
+    sqs_url: https://sqs.ap-northeast-1.amazonaws.com/123456789012/a
+`)
+}
+
+// loadConfig loads data as the current config.
+func loadConfig(t *testing.T, data string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Load(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestServices() *services {
+	store := state.NewMemoryStore()
+	return &services{
+		store:    store,
+		silencer: silence.New(store),
+		stats:    &alertStats{store: store},
+		history:  &history{store: store},
+	}
+}
+
+func slackSignature(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// serveSlack posts form to path of the HTTP API signed as Slack does.
+func serveSlack(svc *services, path string, form url.Values) *httptest.ResponseRecorder {
+	body := form.Encode()
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Slack-Request-Timestamp", ts)
+	r.Header.Set("X-Slack-Signature", slackSignature(testSigningSecret, ts, []byte(body)))
+
+	w := httptest.NewRecorder()
+	newHTTPServer(":0", svc).Handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestVerifySlackSignature(t *testing.T) {
+	now := time.Unix(1531420618, 0)
+	body := []byte("token=x&command=%2Fcwl-alerts&text=silences")
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	tests := []struct {
+		name      string
+		ts        string
+		signature string
+		body      []byte
+		wantErr   bool
+	}{
+		{name: "valid", ts: ts, signature: slackSignature(testSigningSecret, ts, body), body: body},
+		{
+			name:      "4 minutes old",
+			ts:        strconv.FormatInt(now.Add(-4*time.Minute).Unix(), 10),
+			signature: slackSignature(testSigningSecret, strconv.FormatInt(now.Add(-4*time.Minute).Unix(), 10), body),
+			body:      body,
+		},
+		{name: "tampered body", ts: ts, signature: slackSignature(testSigningSecret, ts, body), body: []byte("token=x&text=unsilence"), wantErr: true},
+		{name: "wrong secret", ts: ts, signature: slackSignature("other", ts, body), body: body, wantErr: true},
+		{
+			name:      "stale",
+			ts:        strconv.FormatInt(now.Add(-6*time.Minute).Unix(), 10),
+			signature: slackSignature(testSigningSecret, strconv.FormatInt(now.Add(-6*time.Minute).Unix(), 10), body),
+			body:      body,
+			wantErr:   true,
+		},
+		{
+			name:      "future",
+			ts:        strconv.FormatInt(now.Add(6*time.Minute).Unix(), 10),
+			signature: slackSignature(testSigningSecret, strconv.FormatInt(now.Add(6*time.Minute).Unix(), 10), body),
+			body:      body,
+			wantErr:   true,
+		},
+		{name: "timestamp not signed", ts: strconv.FormatInt(now.Unix()-1, 10), signature: slackSignature(testSigningSecret, ts, body), body: body, wantErr: true},
+		{name: "missing timestamp", signature: slackSignature(testSigningSecret, "", body), body: body, wantErr: true},
+		{name: "invalid timestamp", ts: "now", signature: slackSignature(testSigningSecret, "now", body), body: body, wantErr: true},
+		{name: "missing signature", ts: ts, body: body, wantErr: true},
+		{name: "unversioned signature", ts: ts, signature: strings.TrimPrefix(slackSignature(testSigningSecret, ts, body), "v0="), body: body, wantErr: true},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.ts != "" {
+			header.Set("X-Slack-Request-Timestamp", tt.ts)
+		}
+		if tt.signature != "" {
+			header.Set("X-Slack-Signature", tt.signature)
+		}
+		err := verifySlackSignature(testSigningSecret, header, tt.body, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: verifySlackSignature() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestVerifySlackRejectsUnsigned(t *testing.T) {
+	loadTestConfig(t)
+	svc := newTestServices()
+
+	for _, path := range []string{"/slack/actions", "/slack/commands"} {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader("text=silences"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		newHTTPServer(":0", svc).Handler.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s answered %d to an unsigned request, want 401", path, w.Code)
+		}
+	}
+}
+
+// actSlack presses action on the notification identified by callbackID.
+func actSlack(t *testing.T, svc *services, callbackID string, action slack.AttachmentAction) *httptest.ResponseRecorder {
+	t.Helper()
+	cb := slack.AttachmentActionCallback{
+		CallbackID: callbackID,
+		Actions:    []slack.AttachmentAction{action},
+		User:       slack.User{ID: "U1", Name: "alice"},
+	}
+	cb.OriginalMessage.Attachments = []slack.Attachment{{
+		Actions: []slack.AttachmentAction{{Value: actionAcknowledge}, {Value: actionMute}},
+	}}
+	payload, err := json.Marshal(&cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveSlack(svc, "/slack/actions", url.Values{"payload": {string(payload)}})
+}
+
+func TestSlackActions(t *testing.T) {
+	loadTestConfig(t)
+	svc := newTestServices()
+	ctx := httptest.NewRequest(http.MethodGet, "/", nil).Context()
+
+	in := &notifyInput{
+		Alarm:           &CloudWatchAlarm{AlarmName: "alarm-[1]"},
+		ApplicationName: "/aws/lambda/app*",
+		MaxDisplayed:    2,
+	}
+	for _, msg := range []string{
+		"ERROR timeout after 30s",
+		"ERROR order 42 not found\n\tat Orders.find",
+		"ERROR timeout after 31s",
+		"ERROR disk full",
+	} {
+		in.Events = append(in.Events, render.Event{Message: msg})
+	}
+	actions, err := addActions(ctx, svc.store, "n1", in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 表示されるエラーごとにミュートできる
+	menu := actions[len(actions)-1]
+	want := []slack.AttachmentActionOption{
+		{Text: "ERROR timeout after 30s", Value: in.Events[0].Fingerprint()},
+		{Text: "ERROR order 42 not found", Value: in.Events[1].Fingerprint()},
+	}
+	if menu.Name != actionMuteFingerprint || menu.Type != "select" || !reflect.DeepEqual(menu.Options, want) {
+		t.Errorf("mute error menu = %+v, want options %+v", menu, want)
+	}
+
+	act := func(action slack.AttachmentAction) slack.Msg {
+		t.Helper()
+		w := actSlack(t, svc, "n1", action)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%+v answered %d: %s", action, w.Code, w.Body)
+		}
+		var msg slack.Msg
+		if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	button := func(value string) slack.AttachmentAction {
+		return slack.AttachmentAction{Name: "action", Value: value}
+	}
+
+	// 確認済みにすると確認ボタンが消える
+	msg := act(button(actionAcknowledge))
+	if !msg.ReplaceOriginal || len(msg.Attachments) != 2 {
+		t.Fatalf("ack response = %+v", msg)
+	}
+	if got := msg.Attachments[0].Actions; len(got) != 1 || got[0].Value != actionMute {
+		t.Errorf("actions after ack = %+v, want only mute", got)
+	}
+	if !strings.Contains(msg.Attachments[1].Text, "Acknowledged by <@U1>") {
+		t.Errorf("ack note = %q", msg.Attachments[1].Text)
+	}
+
+	data, err := svc.store.Get(ctx, actionsKey("n1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var na notificationActions
+	if err := json.Unmarshal(data, &na); err != nil {
+		t.Fatal(err)
+	}
+	if na.AckedBy != "<@U1>" || na.AckedAt.IsZero() {
+		t.Errorf("stored actions = %+v, want acked by <@U1>", na)
+	}
+
+	before := time.Now()
+	act(button(actionMute))
+	act(slack.AttachmentAction{Name: actionMuteFingerprint, SelectedOptions: want[1:]})
+
+	// 表示されていないエラーはミュートできない
+	hidden := []slack.AttachmentActionOption{{Value: in.Events[3].Fingerprint()}}
+	if w := actSlack(t, svc, "n1", slack.AttachmentAction{Name: actionMuteFingerprint, SelectedOptions: hidden}); w.Code != http.StatusBadRequest {
+		t.Errorf("muting a hidden error answered %d, want 400", w.Code)
+	}
+	if w := actSlack(t, svc, "n1", button(actionMuteFingerprint)); w.Code != http.StatusBadRequest {
+		t.Errorf("muting without a selected error answered %d, want 400", w.Code)
+	}
+
+	silences, err := svc.silencer.List(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(silences) != 2 {
+		t.Fatalf("silences = %+v, want 2", silences)
+	}
+	mute, muteFP := silences[0], silences[1]
+	if mute.Alarm != `alarm-\[1\]` || mute.App != `/aws/lambda/app\*` || mute.Fingerprint != "" || mute.CreatedBy != "alice" {
+		t.Errorf("mute silence = %+v", mute)
+	}
+	if d := mute.EndsAt.Sub(before); d < muteDuration || d > muteDuration+time.Minute {
+		t.Errorf("mute silence lasts %s, want %s", d, muteDuration)
+	}
+	if want := in.Events[1].Fingerprint(); muteFP.Fingerprint != want || muteFP.App != mute.App {
+		t.Errorf("mute error silence = %+v, want fingerprint %s", muteFP, want)
+	}
+	if d := muteFP.EndsAt.Sub(before); d < muteFingerprintDuration || d > muteFingerprintDuration+time.Minute {
+		t.Errorf("mute error silence lasts %s, want %s", d, muteFingerprintDuration)
+	}
+
+	// 作成したサイレンスは同じアプリケーションのイベントにだけ一致する
+	e := silence.Event{AlarmName: "alarm-[1]", AppName: "/aws/lambda/app*"}
+	if silence.Match(silences[:1], e, time.Now()) == nil {
+		t.Error("mute silence does not match the application")
+	}
+	e.AppName = "/aws/lambda/app2"
+	if silence.Match(silences[:1], e, time.Now()) != nil {
+		t.Error("mute silence matches another application")
+	}
+}
+
+func TestSlackActionsMuteErrorButton(t *testing.T) {
+	loadTestConfig(t)
+	svc := newTestServices()
+	ctx := httptest.NewRequest(http.MethodGet, "/", nil).Context()
+
+	// メニュー以前の通知はボタンで最初のエラーをミュートする
+	if err := saveActions(ctx, svc.store, "old", &notificationActions{ApplicationName: "app", Fingerprint: "0123456789ab"}); err != nil {
+		t.Fatal(err)
+	}
+	w := actSlack(t, svc, "old", slack.AttachmentAction{Name: "action", Value: actionMuteFingerprint})
+	if w.Code != http.StatusOK {
+		t.Fatalf("answered %d: %s", w.Code, w.Body)
+	}
+	silences, err := svc.silencer.List(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(silences) != 1 || silences[0].Fingerprint != "0123456789ab" {
+		t.Errorf("silences = %+v, want the error of the notification muted", silences)
+	}
+}
+
+func TestSlackActionsExpired(t *testing.T) {
+	loadTestConfig(t)
+	payload := `{"callback_id":"gone","actions":[{"name":"action","value":"ack"}],"user":{"id":"U1"}}`
+	w := serveSlack(newTestServices(), "/slack/actions", url.Values{"payload": {payload}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "too old") {
+		t.Errorf("response = %d %s, want a note that the notification is too old", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,163 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("state")
+
+// BoltStore is a Store kept in a local bbolt file.
+//
+// Each value is prefixed with its expiry as 8 bytes of big-endian Unix nanoseconds,
+// 0 meaning no expiry.
+type BoltStore struct {
+	db        *bolt.DB
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// OpenBoltStore opens or creates the bbolt file at path.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	s := &BoltStore{db: db}
+	if err := s.sweep(time.Now()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func encodeBoltValue(value []byte, expiresAt time.Time) []byte {
+	data := make([]byte, 8+len(value))
+	if !expiresAt.IsZero() {
+		binary.BigEndian.PutUint64(data, uint64(expiresAt.UnixNano()))
+	}
+	copy(data[8:], value)
+	return data
+}
+
+// decodeBoltValue returns the value of data, or false when it has expired at now.
+func decodeBoltValue(data []byte, now time.Time) ([]byte, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+	if exp := int64(binary.BigEndian.Uint64(data)); exp != 0 && now.UnixNano() >= exp {
+		return nil, false
+	}
+	return append([]byte(nil), data[8:]...), true
+}
+
+func (s *BoltStore) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v, ok := decodeBoltValue(tx.Bucket(boltBucket).Get([]byte(key)), time.Now())
+		if !ok {
+			return ErrNotFound
+		}
+		value = v
+		return nil
+	})
+	if err == ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return value, nil
+}
+
+func (s *BoltStore) Put(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	now := time.Now()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), encodeBoltValue(value, expiresAt(now, ttl)))
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	s.mu.Lock()
+	due := now.Sub(s.lastSweep) >= sweepInterval
+	s.mu.Unlock()
+	if due {
+		return s.sweep(now)
+	}
+	return nil
+}
+
+func (s *BoltStore) Delete(ctx context.Context, key string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+	return errors.WithStack(err)
+}
+
+func (s *BoltStore) List(ctx context.Context, prefix string) ([]Item, error) {
+	now := time.Now()
+	var items []Item
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		p := []byte(prefix)
+		for k, data := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, data = c.Next() {
+			if v, ok := decodeBoltValue(data, now); ok {
+				items = append(items, Item{Key: string(k), Value: v})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return items, nil
+}
+
+func (s *BoltStore) Close() error {
+	return errors.WithStack(s.db.Close())
+}
+
+// sweep removes expired entries.
+func (s *BoltStore) sweep(now time.Time) error {
+	s.mu.Lock()
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var expired [][]byte
+		b := tx.Bucket(boltBucket)
+		err := b.ForEach(func(k, data []byte) error {
+			if _, ok := decodeBoltValue(data, now); !ok {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return errors.WithStack(err)
+}
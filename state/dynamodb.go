@@ -0,0 +1,130 @@
+package state
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+)
+
+// DynamoDBStore is a Store kept in a DynamoDB table.
+//
+// The table has the string partition key "kind" and the string sort key "key".
+// "expires_at" holds the expiry in Unix seconds and can be enabled as the TTL
+// attribute of the table; expired items are ignored until DynamoDB removes them.
+type DynamoDBStore struct {
+	api   dynamodbiface.DynamoDBAPI
+	table string
+}
+
+const (
+	dynamoKindAttr      = "kind"
+	dynamoKeyAttr       = "key"
+	dynamoValueAttr     = "value"
+	dynamoExpiresAtAttr = "expires_at"
+)
+
+func NewDynamoDBStore(api dynamodbiface.DynamoDBAPI, table string) *DynamoDBStore {
+	return &DynamoDBStore{
+		api:   api,
+		table: table,
+	}
+}
+
+func dynamoKey(key string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		dynamoKindAttr: {S: aws.String(kind(key))},
+		dynamoKeyAttr:  {S: aws.String(key)},
+	}
+}
+
+// decodeDynamoItem returns the value of item, or false when it has expired at now.
+func decodeDynamoItem(item map[string]*dynamodb.AttributeValue, now time.Time) ([]byte, bool) {
+	if exp, ok := item[dynamoExpiresAtAttr]; ok && exp.N != nil {
+		sec, err := strconv.ParseInt(*exp.N, 10, 64)
+		if err == nil && now.Unix() >= sec {
+			return nil, false
+		}
+	}
+	v, ok := item[dynamoValueAttr]
+	if !ok {
+		return nil, false
+	}
+	return v.B, true
+}
+
+func (s *DynamoDBStore) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.api.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            dynamoKey(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	value, ok := decodeDynamoItem(out.Item, time.Now())
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *DynamoDBStore) Put(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	item := dynamoKey(key)
+	item[dynamoValueAttr] = &dynamodb.AttributeValue{B: value}
+	if exp := expiresAt(time.Now(), ttl); !exp.IsZero() {
+		item[dynamoExpiresAtAttr] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(exp.Unix(), 10))}
+	}
+
+	_, err := s.api.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      item,
+	})
+	return errors.WithStack(err)
+}
+
+func (s *DynamoDBStore) Delete(ctx context.Context, key string) error {
+	_, err := s.api.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key:       dynamoKey(key),
+	})
+	return errors.WithStack(err)
+}
+
+func (s *DynamoDBStore) List(ctx context.Context, prefix string) ([]Item, error) {
+	now := time.Now()
+	var items []Item
+	err := s.api.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.table),
+		KeyConditionExpression: aws.String("#kind = :kind AND begins_with(#key, :prefix)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#kind": aws.String(dynamoKindAttr),
+			"#key":  aws.String(dynamoKeyAttr),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":kind":   {S: aws.String(kind(prefix))},
+			":prefix": {S: aws.String(prefix)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}, func(out *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range out.Items {
+			if v, ok := decodeDynamoItem(item, now); ok {
+				items = append(items, Item{Key: aws.StringValue(item[dynamoKeyAttr].S), Value: v})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return items, nil
+}
+
+func (s *DynamoDBStore) Close() error {
+	return nil
+}
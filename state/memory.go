@@ -0,0 +1,94 @@
+package state
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired entries are removed from a MemoryStore.
+const sweepInterval = time.Minute
+
+// MemoryStore is a Store kept in process memory, lost on restart.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries:   map[string]memoryEntry{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || e.expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), e.value...), nil
+}
+
+func (s *MemoryStore) Put(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.entries[key] = memoryEntry{
+		value:     append([]byte(nil), value...),
+		expiresAt: expiresAt(now, ttl),
+	}
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, e := range s.entries {
+			if e.expired(now) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, prefix string) ([]Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var items []Item
+	for k, e := range s.entries {
+		if strings.HasPrefix(k, prefix) && !e.expired(now) {
+			items = append(items, Item{Key: k, Value: append([]byte(nil), e.value...)})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	return items, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
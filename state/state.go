@@ -0,0 +1,46 @@
+package state
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by Get when the key does not exist or has expired.
+var ErrNotFound = errors.New("state: not found")
+
+// Store is a key-value store for state that has to outlive a single message.
+//
+// Keys take the form "<kind>/<id>", such as "delivery/<notification id>".
+// List prefixes must start with the kind.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put stores value under key. The entry expires after ttl, or never when ttl is 0.
+	Put(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// List returns the unexpired entries whose key starts with prefix, ordered by key.
+	List(ctx context.Context, prefix string) ([]Item, error)
+	Close() error
+}
+
+type Item struct {
+	Key   string
+	Value []byte
+}
+
+// kind returns the part of key before the first "/".
+func kind(key string) string {
+	if i := strings.Index(key, "/"); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+func expiresAt(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
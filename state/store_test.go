@@ -0,0 +1,168 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// dynamoDBEndpointEnv names the DynamoDB Local endpoint, e.g.
+// http://localhost:8000, that the DynamoDB store is tested against.
+const dynamoDBEndpointEnv = "STATE_TEST_DYNAMODB_ENDPOINT"
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := OpenBoltStore(filepath.Join(dir, "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	testStore(t, s)
+}
+
+func TestDynamoDBStore(t *testing.T) {
+	endpoint := os.Getenv(dynamoDBEndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s is not set", dynamoDBEndpointEnv)
+	}
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(endpoint),
+		Credentials: credentials.NewStaticCredentials("local", "local", ""),
+	}))
+	api := dynamodb.New(sess)
+	table := fmt.Sprintf("state-test-%d", time.Now().UnixNano())
+	_, err := api.CreateTable(&dynamodb.CreateTableInput{
+		TableName: aws.String(table),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String(dynamoKindAttr), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			{AttributeName: aws.String(dynamoKeyAttr), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String(dynamoKindAttr), KeyType: aws.String(dynamodb.KeyTypeHash)},
+			{AttributeName: aws.String(dynamoKeyAttr), KeyType: aws.String(dynamodb.KeyTypeRange)},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer api.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(table)})
+
+	testStore(t, NewDynamoDBStore(api, table))
+}
+
+// testStore checks the behavior every Store implementation must share.
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := s.Get(ctx, "test/missing"); err != ErrNotFound {
+			t.Errorf("Get of a missing key = %v, want ErrNotFound", err)
+		}
+		if err := s.Delete(ctx, "test/missing"); err != nil {
+			t.Errorf("Delete of a missing key = %v", err)
+		}
+	})
+
+	t.Run("put, get and delete", func(t *testing.T) {
+		if err := s.Put(ctx, "test/a", []byte("1"), 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(ctx, "test/a", []byte("2"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		v, err := s.Get(ctx, "test/a")
+		if err != nil || string(v) != "2" {
+			t.Errorf("Get = %q, %v, want the overwritten value", v, err)
+		}
+
+		if err := s.Delete(ctx, "test/a"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Get(ctx, "test/a"); err != ErrNotFound {
+			t.Errorf("Get of a deleted key = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("list by prefix in key order", func(t *testing.T) {
+		for _, k := range []string{"list/b/2", "list/a/2", "listx/a/1", "list/b/1", "list/a/1", "other/list/a"} {
+			if err := s.Put(ctx, k, []byte(k), 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			prefix string
+			want   []string
+		}{
+			{prefix: "list/", want: []string{"list/a/1", "list/a/2", "list/b/1", "list/b/2"}},
+			{prefix: "list/b/", want: []string{"list/b/1", "list/b/2"}},
+			{prefix: "list/c/", want: nil},
+		}
+		for _, tt := range tests {
+			items, err := s.List(ctx, tt.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, item := range items {
+				if item.Key != string(item.Value) {
+					t.Errorf("List(%q) item %s has value %q", tt.prefix, item.Key, item.Value)
+				}
+				keys = append(keys, item.Key)
+			}
+			if fmt.Sprint(keys) != fmt.Sprint(tt.want) {
+				t.Errorf("List(%q) = %v, want %v", tt.prefix, keys, tt.want)
+			}
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		if err := s.Put(ctx, "ttl/expiring", []byte("x"), time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(ctx, "ttl/kept", []byte("y"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Get(ctx, "ttl/expiring"); err != nil {
+			t.Errorf("Get before expiry = %v", err)
+		}
+
+		// DynamoDBは秒単位で期限を持つので1秒以上待つ
+		time.Sleep(1100 * time.Millisecond)
+
+		if _, err := s.Get(ctx, "ttl/expiring"); err != ErrNotFound {
+			t.Errorf("Get after expiry = %v, want ErrNotFound", err)
+		}
+		items, err := s.List(ctx, "ttl/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 || items[0].Key != "ttl/kept" {
+			t.Errorf("List after expiry = %v, want only ttl/kept", items)
+		}
+	})
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/yuichiro-h/cwl-alert-notifier/config"
+	"github.com/yuichiro-h/cwl-alert-notifier/state"
+)
+
+func openStore(sess *session.Session, c config.StateConfig) (state.Store, error) {
+	switch c.Type {
+	case "file":
+		return state.OpenBoltStore(c.Path)
+	case "dynamodb":
+		cfg := aws.NewConfig()
+		if c.DynamoDB.Region != "" {
+			cfg = cfg.WithRegion(c.DynamoDB.Region)
+		}
+		if c.DynamoDB.Endpoint != "" {
+			cfg = cfg.WithEndpoint(c.DynamoDB.Endpoint)
+		}
+		return state.NewDynamoDBStore(dynamodb.New(sess, cfg), c.DynamoDB.Table), nil
+	default:
+		return state.NewMemoryStore(), nil
+	}
+}
@@ -0,0 +1,163 @@
+package window
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+)
+
+// Window is a recurring period of time, given either as a cron schedule with a
+// duration (a maintenance window) or as a daily time range (quiet hours).
+type Window struct {
+	// Schedule is a standard 5-field cron expression for when the window opens.
+	Schedule string `yaml:"schedule"`
+	// Duration is how long the window stays open in seconds.
+	Duration int64 `yaml:"duration"`
+
+	// Start and End are "15:04" times of day. End may be before Start to span midnight.
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	// Days restricts quiet hours to the days they start on, e.g. ["sat", "sun"].
+	Days []string `yaml:"days"`
+
+	// Timezone is an IANA time zone name, UTC by default.
+	Timezone string `yaml:"timezone"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Validate reports the first problem of w.
+func (w *Window) Validate() error {
+	if _, err := w.location(); err != nil {
+		return err
+	}
+
+	if w.Schedule != "" {
+		if w.Start != "" || w.End != "" || len(w.Days) > 0 {
+			return errors.New("schedule cannot be combined with start, end or days")
+		}
+		if _, err := ParseSchedule(w.Schedule, w.Timezone); err != nil {
+			return err
+		}
+		if w.Duration <= 0 {
+			return errors.New("duration must be positive")
+		}
+		return nil
+	}
+
+	if w.Start == "" || w.End == "" {
+		return errors.New("either schedule and duration, or start and end are required")
+	}
+	for _, v := range []string{w.Start, w.End} {
+		if _, err := time.Parse("15:04", v); err != nil {
+			return errors.Errorf("invalid time of day %q", v)
+		}
+	}
+	for _, d := range w.Days {
+		if _, ok := weekdays[strings.ToLower(d)]; !ok {
+			return errors.Errorf("invalid day %q", d)
+		}
+	}
+	return nil
+}
+
+func (w *Window) location() (*time.Location, error) {
+	return loadLocation(w.Timezone)
+}
+
+func loadLocation(timezone string) (*time.Location, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid timezone %q", timezone)
+	}
+	return loc, nil
+}
+
+// ParseSchedule parses a standard 5-field cron expression evaluated in timezone.
+func ParseSchedule(schedule, timezone string) (cron.Schedule, error) {
+	if _, err := loadLocation(timezone); err != nil {
+		return nil, err
+	}
+	sched, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid schedule %q", schedule)
+	}
+	return sched, nil
+}
+
+// ActiveUntil returns the end of the occurrence of w that contains t, and
+// false when w is not open at t. w must be valid.
+func (w *Window) ActiveUntil(t time.Time) (time.Time, bool) {
+	loc, err := w.location()
+	if err != nil {
+		return time.Time{}, false
+	}
+	t = t.In(loc)
+
+	if w.Schedule != "" {
+		sched, err := cron.ParseStandard(w.Schedule)
+		if err != nil {
+			return time.Time{}, false
+		}
+		// 開始時刻がtより前でdurationの範囲内にある回を探す。
+		// cronは夏時間の開始で存在しない時刻の回を飛ばすので、壁時計の時刻で
+		// 回を求めてから実際の時刻に直す
+		d := time.Duration(w.Duration) * time.Second
+		wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+		for next := sched.Next(wall.Add(-d - time.Hour - time.Second)); !next.IsZero() && !next.After(wall.Add(time.Hour)); next = sched.Next(next) {
+			start := time.Date(next.Year(), next.Month(), next.Day(), next.Hour(), next.Minute(), 0, 0, loc)
+			startWall := time.Date(start.Year(), start.Month(), start.Day(), start.Hour(), start.Minute(), 0, 0, time.UTC)
+			if startWall.Before(next) {
+				// 存在しない時刻は飛ばされた分だけ後ろにずらす
+				start = start.Add(next.Sub(startWall))
+			}
+			if start.After(t) {
+				break
+			}
+			if end := start.Add(d); t.Before(end) {
+				return end, true
+			}
+		}
+		return time.Time{}, false
+	}
+
+	startOfDay, _ := time.Parse("15:04", w.Start)
+	endOfDay, _ := time.Parse("15:04", w.End)
+	for _, offset := range []int{0, -1} {
+		day := t.AddDate(0, 0, offset)
+		if !w.onDay(day.Weekday()) {
+			continue
+		}
+		start := time.Date(day.Year(), day.Month(), day.Day(), startOfDay.Hour(), startOfDay.Minute(), 0, 0, loc)
+		end := time.Date(day.Year(), day.Month(), day.Day(), endOfDay.Hour(), endOfDay.Minute(), 0, 0, loc)
+		if !end.After(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+		if !t.Before(start) && t.Before(end) {
+			return end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (w *Window) onDay(d time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, day := range w.Days {
+		if weekdays[strings.ToLower(day)] == d {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package window
+
+import (
+	"testing"
+	"time"
+)
+
+func mustTime(t *testing.T, loc *time.Location, s string) time.Time {
+	t.Helper()
+	v, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestActiveUntilQuietHours(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2026-10-16 is a Friday.
+	overnight := Window{Start: "22:00", End: "06:00", Days: []string{"Fri"}, Timezone: "Asia/Tokyo"}
+	daytime := Window{Start: "09:00", End: "18:00", Timezone: "Asia/Tokyo"}
+
+	tests := []struct {
+		name    string
+		w       Window
+		at      string
+		active  bool
+		wantEnd string
+	}{
+		{name: "before start on the day", w: overnight, at: "2026-10-16 21:59", active: false},
+		{name: "at start", w: overnight, at: "2026-10-16 22:00", active: true, wantEnd: "2026-10-17 06:00"},
+		{name: "after midnight of the day", w: overnight, at: "2026-10-17 03:00", active: true, wantEnd: "2026-10-17 06:00"},
+		{name: "at end", w: overnight, at: "2026-10-17 06:00", active: false},
+		{name: "night of another day", w: overnight, at: "2026-10-17 23:00", active: false},
+		{name: "morning after another day", w: overnight, at: "2026-10-16 03:00", active: false},
+		{name: "within a daily range", w: daytime, at: "2026-10-18 12:00", active: true, wantEnd: "2026-10-18 18:00"},
+		{name: "outside a daily range", w: daytime, at: "2026-10-18 20:00", active: false},
+	}
+	for _, tt := range tests {
+		if err := tt.w.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		// 別のタイムゾーンで渡しても窓のタイムゾーンで判定される
+		at := mustTime(t, tokyo, tt.at).UTC()
+		end, ok := tt.w.ActiveUntil(at)
+		if ok != tt.active {
+			t.Errorf("%s: active = %v, want %v", tt.name, ok, tt.active)
+			continue
+		}
+		if ok && !end.Equal(mustTime(t, tokyo, tt.wantEnd)) {
+			t.Errorf("%s: end = %v, want %s", tt.name, end, tt.wantEnd)
+		}
+	}
+}
+
+func TestActiveUntilSchedule(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2026-03-08 02:00 EST jumps to 03:00 EDT, and 2026-11-01 02:00 EDT falls back to 01:00 EST.
+	nightly := Window{Schedule: "0 1 * * *", Duration: 3 * 60 * 60, Timezone: "America/New_York"}
+	lateNight := Window{Schedule: "30 2 * * *", Duration: 60 * 60, Timezone: "America/New_York"}
+	sunday := Window{Schedule: "30 23 * * 0", Duration: 60 * 60, Timezone: "America/New_York"}
+
+	tests := []struct {
+		name    string
+		w       Window
+		at      time.Time
+		active  bool
+		wantEnd time.Time
+	}{
+		{name: "before opening", w: nightly, at: mustTime(t, ny, "2026-03-07 00:59"), active: false},
+		{name: "at opening", w: nightly, at: mustTime(t, ny, "2026-03-07 01:00"), active: true, wantEnd: mustTime(t, ny, "2026-03-07 04:00")},
+		{name: "at closing", w: nightly, at: mustTime(t, ny, "2026-03-07 04:00"), active: false},
+		// 夏時間の開始日は3時間後が壁時計で5時になる
+		{name: "spring forward", w: nightly, at: mustTime(t, ny, "2026-03-08 04:30"), active: true, wantEnd: mustTime(t, ny, "2026-03-08 05:00")},
+		{name: "after spring forward closing", w: nightly, at: mustTime(t, ny, "2026-03-08 05:00"), active: false},
+		// 夏時間の終了日は3時間後が壁時計で3時になる
+		{name: "fall back", w: nightly, at: mustTime(t, ny, "2026-11-01 02:30"), active: true, wantEnd: mustTime(t, ny, "2026-11-01 03:00")},
+		{name: "after fall back closing", w: nightly, at: mustTime(t, ny, "2026-11-01 03:00"), active: false},
+		// 存在しない2:30の回は3:30に開く
+		{name: "start skipped by spring forward", w: lateNight, at: mustTime(t, ny, "2026-03-08 03:40"), active: true, wantEnd: mustTime(t, ny, "2026-03-08 04:30")},
+		{name: "before start skipped by spring forward", w: lateNight, at: mustTime(t, ny, "2026-03-08 03:20"), active: false},
+		{name: "day after spring forward", w: lateNight, at: mustTime(t, ny, "2026-03-09 02:40"), active: true, wantEnd: mustTime(t, ny, "2026-03-09 03:30")},
+		{name: "across midnight", w: sunday, at: mustTime(t, ny, "2026-10-19 00:15"), active: true, wantEnd: mustTime(t, ny, "2026-10-19 00:30")},
+		{name: "other day", w: sunday, at: mustTime(t, ny, "2026-10-20 00:15"), active: false},
+	}
+	for _, tt := range tests {
+		if err := tt.w.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		end, ok := tt.w.ActiveUntil(tt.at)
+		if ok != tt.active {
+			t.Errorf("%s: active = %v, want %v", tt.name, ok, tt.active)
+			continue
+		}
+		if ok && !end.Equal(tt.wantEnd) {
+			t.Errorf("%s: end = %v, want %v", tt.name, end, tt.wantEnd)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		w     Window
+		valid bool
+	}{
+		{name: "schedule", w: Window{Schedule: "0 1 * * *", Duration: 60}, valid: true},
+		{name: "quiet hours", w: Window{Start: "22:00", End: "06:00", Days: []string{"sat", "SUN"}}, valid: true},
+		{name: "schedule without duration", w: Window{Schedule: "0 1 * * *"}},
+		{name: "schedule with days", w: Window{Schedule: "0 1 * * *", Duration: 60, Days: []string{"sat"}}},
+		{name: "invalid schedule", w: Window{Schedule: "0 25 * * *", Duration: 60}},
+		{name: "missing end", w: Window{Start: "22:00"}},
+		{name: "invalid time", w: Window{Start: "24:00", End: "06:00"}},
+		{name: "invalid day", w: Window{Start: "22:00", End: "06:00", Days: []string{"funday"}}},
+		{name: "invalid timezone", w: Window{Start: "22:00", End: "06:00", Timezone: "Mars/Base"}},
+	}
+	for _, tt := range tests {
+		if err := tt.w.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: Validate() = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}